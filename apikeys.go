@@ -0,0 +1,202 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// apiKeysPath is where API key hashes are kept. It lives outside the images
+// directory so it is never served.
+const apiKeysPath = "apiKeys.json"
+
+// APIKey is one entry of apiKeys.json. Only the SHA-256 of the key is stored.
+type APIKey struct {
+	Label     string     `json:"label"`
+	Hash      string     `json:"hash"`
+	CreatedAt time.Time  `json:"createdAt"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+type APIKeyFile struct {
+	Keys []APIKey `json:"keys"`
+}
+
+var apiKeysMutex sync.Mutex
+
+// loadAPIKeys reads apiKeys.json. A missing file means no keys.
+func loadAPIKeys() (APIKeyFile, error) {
+	var keys APIKeyFile
+	data, err := os.ReadFile(apiKeysPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return keys, nil
+	}
+	if err != nil {
+		return keys, err
+	}
+	err = json.Unmarshal(data, &keys)
+	return keys, err
+}
+
+func saveAPIKeys(keys APIKeyFile) error {
+	data, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(apiKeysPath, data, 0600)
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// validateApiKey reports whether the request carries an unexpired API key in
+// an "Authorization: ApiKey <key>" header.
+func validateApiKey(r *http.Request) bool {
+	key, ok := strings.CutPrefix(r.Header.Get("Authorization"), "ApiKey ")
+	if !ok || key == "" {
+		return false
+	}
+	hash := []byte(hashAPIKey(key))
+
+	apiKeysMutex.Lock()
+	keys, err := loadAPIKeys()
+	apiKeysMutex.Unlock()
+	if err != nil {
+		return false
+	}
+	now := time.Now()
+	for _, k := range keys.Keys {
+		if subtle.ConstantTimeCompare(hash, []byte(k.Hash)) == 1 {
+			return k.ExpiresAt == nil || now.Before(*k.ExpiresAt)
+		}
+	}
+	return false
+}
+
+// requireAPIKey wraps a handler so that, with -requireApiKey set, it only
+// runs for requests with a valid API key or the admin token.
+func requireAPIKey(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if *requireApiKey && !validateApiKey(r) && !isAdmin(r) {
+			http.Error(w, "API key required", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+type GenerateAPIKeyRequest struct {
+	Label     string     `json:"label"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+// generateAPIKeyHandler creates a new API key. The key itself is only ever
+// returned in this response.
+func generateAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	var req GenerateAPIKeyRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		http.Error(w, "expiresAt must be in the future", http.StatusBadRequest)
+		return
+	}
+
+	var raw [32]byte
+	if _, err := rand.Read(raw[:]); err != nil {
+		http.Error(w, "Error generating key: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	key := hex.EncodeToString(raw[:])
+	entry := APIKey{Label: req.Label, Hash: hashAPIKey(key), CreatedAt: time.Now().UTC(), ExpiresAt: req.ExpiresAt}
+	if entry.Label == "" {
+		entry.Label = "key_" + entry.Hash[:8]
+	}
+
+	apiKeysMutex.Lock()
+	defer apiKeysMutex.Unlock()
+
+	keys, err := loadAPIKeys()
+	if err != nil {
+		http.Error(w, "Error reading apiKeys.json: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, k := range keys.Keys {
+		if k.Label == entry.Label {
+			http.Error(w, "An API key with that label already exists", http.StatusConflict)
+			return
+		}
+	}
+	keys.Keys = append(keys.Keys, entry)
+	if err := saveAPIKeys(keys); err != nil {
+		http.Error(w, "Error writing apiKeys.json: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"key":       key,
+		"label":     entry.Label,
+		"expiresAt": entry.ExpiresAt,
+	})
+}
+
+func revokeAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "DELETE required", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	label := r.URL.Query().Get("label")
+	if label == "" {
+		http.Error(w, "label is required", http.StatusBadRequest)
+		return
+	}
+
+	apiKeysMutex.Lock()
+	defer apiKeysMutex.Unlock()
+
+	keys, err := loadAPIKeys()
+	if err != nil {
+		http.Error(w, "Error reading apiKeys.json: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	n := len(keys.Keys)
+	keys.Keys = slices.DeleteFunc(keys.Keys, func(k APIKey) bool { return k.Label == label })
+	if len(keys.Keys) == n {
+		http.Error(w, "No API key with that label", http.StatusNotFound)
+		return
+	}
+	if err := saveAPIKeys(keys); err != nil {
+		http.Error(w, "Error writing apiKeys.json: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"status": "ok", "label": label})
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// requireAdmin checks the admin token sent in the X-Admin-Token header or as
+// an "Authorization: Bearer" header. It writes an error response and returns
+// false if the request is not authorised.
+func requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if *adminToken == "" {
+		http.Error(w, "Admin endpoints are disabled: start the server with -adminToken", http.StatusForbidden)
+		return false
+	}
+	if !isAdmin(r) {
+		http.Error(w, "Admin token required", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// isAdmin reports whether the request carries a valid admin token.
+func isAdmin(r *http.Request) bool {
+	if *adminToken == "" {
+		return false
+	}
+	token := r.Header.Get("X-Admin-Token")
+	if token == "" {
+		token = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(*adminToken)) == 1
+}
+
+// parseClientIP returns the IP address of the client that sent the request.
+// When trustProxy is set the first address in X-Forwarded-For, or else
+// X-Real-IP, is used if it is a valid IP; otherwise r.RemoteAddr is used.
+func parseClientIP(r *http.Request, trustProxy bool) string {
+	if trustProxy {
+		forwarded := r.Header.Get("X-Forwarded-For")
+		if first, _, _ := strings.Cut(forwarded, ","); first != "" {
+			if ip := net.ParseIP(strings.TrimSpace(first)); ip != nil {
+				return ip.String()
+			}
+		}
+		if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+			return ip.String()
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// ipHashKeyFile holds the random key IP addresses are hashed with when no
+// -ipHashSecret is given. It is a dot file in the images directory, so it is
+// never taken for a puzzle or served.
+const ipHashKeyFile = ".ipHashKey"
+
+// ipHashKey returns the HMAC key of hashIP: -ipHashSecret, or else a random
+// key kept in ipHashKeyFile so hashes stay comparable across restarts.
+var ipHashKey = sync.OnceValue(func() []byte {
+	if *ipHashSecret != "" {
+		return []byte(*ipHashSecret)
+	}
+	path := filepath.Join(defaultStore.root, ipHashKeyFile)
+	if key, err := os.ReadFile(path); err == nil && len(key) > 0 {
+		return key
+	}
+	key := make([]byte, 32)
+	rand.Read(key)
+	if err := os.WriteFile(path, key, 0600); err != nil {
+		log.Printf("WARNING: could not save the IP hash key, uploader hashes change on restart: %v", err)
+	}
+	return key
+})
+
+// hashIP returns the hex encoded HMAC-SHA256 of an IP address so uploads
+// can be traced without storing the address itself. It is keyed rather
+// than the plain SHA-256 uploaderIP was first specified with: the small
+// IPv4 space could be hashed through to undo a plain hash. Since nobody
+// without the key can compute it, /uploaderReport also takes the address.
+func hashIP(ip string) string {
+	mac := hmac.New(sha256.New, ipHashKey())
+	mac.Write([]byte(ip))
+	return hex.EncodeToString(mac.Sum(nil))
+}
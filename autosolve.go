@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// autoSolveHandler returns the manifest solution of a puzzle as an
+// ExportPayload, ready to be posted to /exportPuzzle. It is admin only as
+// it gives the puzzle away.
+func autoSolveHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	var req struct {
+		Folder string `json:"folder"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !validFolderName(req.Folder) {
+		http.Error(w, "Invalid folder name", http.StatusBadRequest)
+		return
+	}
+	if !requirePuzzleVisible(w, r, req.Folder) {
+		return
+	}
+
+	manifest, err := storeFor(r).loadManifest(req.Folder)
+	if err != nil {
+		http.Error(w, "Error reading manifest.json: "+err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ExportPayload{Folder: req.Folder, Placements: placementsFromSolution(manifest.Solution)})
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// backupAllHandler streams a ZIP of imageIndex.json and, for every indexed
+// puzzle, its index image, manifest.json and tiles.
+func backupAllHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	st := storeFor(r)
+	imageIndexMutex.Lock()
+	imageIndex, err := st.loadImageIndex()
+	imageIndexMutex.Unlock()
+	if err != nil {
+		http.Error(w, "Error reading imageIndex.json: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	start := time.Now()
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="backup_%s.zip"`, start.UTC().Format("20060102_150405")))
+
+	out := &countingWriter{w: w}
+	zw := zip.NewWriter(out)
+	if err := writeBackup(zw, st, imageIndex); err != nil {
+		// The response has started, so all we can do is cut it short
+		log.Printf("backupAll: %v", err)
+		return
+	}
+	if err := zw.Close(); err != nil {
+		log.Printf("backupAll: %v", err)
+		return
+	}
+	log.Printf("INFO: backupAll wrote %d bytes in %s", out.n, time.Since(start).Round(time.Millisecond))
+}
+
+func writeBackup(zw *zip.Writer, st *store, imageIndex ImageIndex) error {
+	if err := addZipFile(zw, st.imageIndexPath(), "imageIndex.json"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, entry := range imageIndex.Images {
+		manifest, err := st.loadManifest(entry.Folder)
+		if err != nil {
+			log.Printf("backupAll: skipping %s: %v", entry.Folder, err)
+			continue
+		}
+		puzzlePath := st.puzzlePath(entry.Folder)
+		files := []string{indexFileName(manifest.IndexFormat), "manifest.json"}
+		for _, piece := range manifest.Pieces {
+			files = append(files, "pieces/"+piece.File)
+		}
+		for _, file := range files {
+			if err := addZipFile(zw, filepath.Join(puzzlePath, filepath.FromSlash(file)), entry.Folder+"/"+file); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
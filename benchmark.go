@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image/png"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// benchmarkLevels maps the zlib style levels reported to clients onto the
+// compression levels image/png supports.
+var benchmarkLevels = []struct {
+	level int
+	png   png.CompressionLevel
+}{
+	{1, png.BestSpeed},
+	{6, png.DefaultCompression},
+	{9, png.BestCompression},
+}
+
+// CompressionResult is one entry of the /benchmarkCompression response.
+type CompressionResult struct {
+	Level      int   `json:"level"`
+	DurationMs int64 `json:"durationMs"`
+	SizeBytes  int   `json:"sizeBytes"`
+}
+
+// benchmarkCompressionHandler re-encodes the first tile of a puzzle at each
+// compression level and reports the time taken and resulting size. Nothing
+// is written to disk.
+func benchmarkCompressionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Folder string `json:"folder"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !validFolderName(req.Folder) {
+		http.Error(w, "Invalid folder name", http.StatusBadRequest)
+		return
+	}
+	if !requirePuzzleVisible(w, r, req.Folder) {
+		return
+	}
+
+	st := storeFor(r)
+	manifest, err := st.loadManifest(req.Folder)
+	if err != nil {
+		http.Error(w, "Error reading manifest.json: "+err.Error(), http.StatusNotFound)
+		return
+	}
+	if len(manifest.Pieces) == 0 {
+		http.Error(w, "Puzzle has no tiles", http.StatusNotFound)
+		return
+	}
+
+	f, err := os.Open(filepath.Join(st.puzzlePath(req.Folder), "pieces", manifest.Pieces[0].File))
+	if err != nil {
+		http.Error(w, "Error opening tile: "+err.Error(), http.StatusNotFound)
+		return
+	}
+	tile, err := png.Decode(f)
+	f.Close()
+	if err != nil {
+		http.Error(w, "Error decoding tile: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	results := make([]CompressionResult, 0, len(benchmarkLevels))
+	for _, l := range benchmarkLevels {
+		var buf bytes.Buffer
+		enc := png.Encoder{CompressionLevel: l.png}
+		start := time.Now()
+		if err := enc.Encode(&buf, tile); err != nil {
+			http.Error(w, "Error encoding tile: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		results = append(results, CompressionResult{
+			Level:      l.level,
+			DurationMs: time.Since(start).Milliseconds(),
+			SizeBytes:  buf.Len(),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"image"
+	"image/draw"
+	"sync"
+)
+
+// defaultCanvasBands is how many horizontal bands a SafeCanvas is split into.
+const defaultCanvasBands = 16
+
+// SafeCanvas is an image.RGBA that tiles can be drawn onto from several
+// goroutines. image.RGBA itself has no locking, so the canvas is split into
+// horizontal bands, each guarded by its own mutex; drawing a tile only locks
+// the bands it overlaps.
+type SafeCanvas struct {
+	*image.RGBA
+	tileSize   int
+	bandHeight int
+	bands      []sync.Mutex
+
+	// ColumnX gives the left edge of a column; columns are tileSize wide
+	// when it is nil
+	ColumnX func(col int) int
+}
+
+// NewSafeCanvas creates a width×height canvas for tiles of tileSize pixels,
+// split into defaultCanvasBands bands.
+func NewSafeCanvas(width, height, tileSize int) *SafeCanvas {
+	bands := min(defaultCanvasBands, max(height, 1))
+	return &SafeCanvas{
+		RGBA:       image.NewRGBA(image.Rect(0, 0, width, height)),
+		tileSize:   tileSize,
+		bandHeight: (height + bands - 1) / bands,
+		bands:      make([]sync.Mutex, bands),
+	}
+}
+
+// SafeDrawTile draws img over the grid cell at row, col. The overlapped
+// bands are locked in ascending order so concurrent calls cannot deadlock.
+func (c *SafeCanvas) SafeDrawTile(row, col int, img image.Image) {
+	x := col * c.tileSize
+	if c.ColumnX != nil {
+		x = c.ColumnX(col)
+	}
+	pt := image.Pt(x, row*c.tileSize)
+	rect := image.Rectangle{Min: pt, Max: pt.Add(img.Bounds().Size())}.Intersect(c.Bounds())
+	if rect.Empty() {
+		return
+	}
+
+	first := rect.Min.Y / c.bandHeight
+	last := (rect.Max.Y - 1) / c.bandHeight
+	for i := first; i <= last; i++ {
+		c.bands[i].Lock()
+	}
+	draw.Draw(c.RGBA, rect, img, img.Bounds().Min, draw.Over)
+	for i := first; i <= last; i++ {
+		c.bands[i].Unlock()
+	}
+}
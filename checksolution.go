@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+)
+
+type CheckSolutionRequest struct {
+	Folder     string            `json:"folder"`
+	Placements map[string]string `json:"placements"` // "row,col":"filename"
+}
+
+// SolutionCheck tells a player how a board compares with the solution
+// without revealing where the misplaced tiles belong.
+type SolutionCheck struct {
+	Solved    bool     `json:"solved"`
+	Incorrect []string `json:"incorrect"` // positions holding the wrong tile, sorted
+}
+
+// checkPlacements compares a board with a manifest's solution. The board is
+// solved when every position holds its tile and nothing else is placed.
+func checkPlacements(manifest *Manifest, placements map[string]string) SolutionCheck {
+	check := SolutionCheck{Incorrect: []string{}}
+	for pos, file := range placements {
+		if manifest.Solution[pos] != file {
+			check.Incorrect = append(check.Incorrect, pos)
+		}
+	}
+	sort.Strings(check.Incorrect)
+	check.Solved = len(check.Incorrect) == 0 && len(placements) == len(manifest.Solution)
+	return check
+}
+
+// checkSolutionHandler serves POST /checkSolution for clients that, unlike
+// admins, never see the solution itself.
+func checkSolutionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req CheckSolutionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !validFolderName(req.Folder) {
+		http.Error(w, "Invalid folder name", http.StatusBadRequest)
+		return
+	}
+	if !requirePuzzleVisible(w, r, req.Folder) {
+		return
+	}
+
+	manifest, err := storeFor(r).loadManifest(req.Folder)
+	if err != nil {
+		http.Error(w, "Error reading manifest.json: "+err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(checkPlacements(manifest, req.Placements))
+}
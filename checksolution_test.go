@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestCheckSolution(t *testing.T) {
+	mux, st := newTestMux(t)
+	mustUpload(t, mux, encodePNG(t, testImage(128, 128, 1)), map[string]string{
+		"name": "foo", "columns": "2", "tileSize": "64",
+	})
+	manifest, err := st.loadManifest("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	solved := map[string]string{}
+	for pos, file := range manifest.Solution {
+		solved[pos] = file
+	}
+	swapped := map[string]string{}
+	for pos, file := range solved {
+		swapped[pos] = file
+	}
+	swapped["0,0"], swapped["0,1"] = solved["0,1"], solved["0,0"]
+	partial := map[string]string{"1,1": solved["1,1"]}
+
+	tests := []struct {
+		name       string
+		placements map[string]string
+		want       SolutionCheck
+	}{
+		{"solved", solved, SolutionCheck{Solved: true, Incorrect: []string{}}},
+		{"swapped", swapped, SolutionCheck{Incorrect: []string{"0,0", "0,1"}}},
+		{"partial", partial, SolutionCheck{Incorrect: []string{}}},
+		{"empty", nil, SolutionCheck{Incorrect: []string{}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := postJSON(t, mux, "/checkSolution", CheckSolutionRequest{Folder: "foo", Placements: tt.placements})
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body)
+			}
+			var got SolutionCheck
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("unknown puzzle", func(t *testing.T) {
+		rec := postJSON(t, mux, "/checkSolution", CheckSolutionRequest{Folder: "bar"})
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("status %d, want 404", rec.Code)
+		}
+	})
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	cleanupInterval = 5 * time.Minute
+	stateMaxAge     = 24 * time.Hour
+)
+
+// runCleanup periodically removes stale files from every store.
+func runCleanup() {
+	ticker := time.NewTicker(cleanupInterval)
+	defer ticker.Stop()
+	for {
+		for _, st := range allStores() {
+			cleanupStore(st)
+		}
+		<-ticker.C
+	}
+}
+
+func cleanupStore(st *store) {
+	removeOlderThan(filepath.Join(st.exportsPath(), "*"), exportFileMaxAge)
+
+	folders, err := st.puzzleFolders()
+	if err != nil {
+		log.Printf("Cleanup: cannot read %s: %v", st.root, err)
+		return
+	}
+	for _, folder := range folders {
+		removeOlderThan(filepath.Join(st.puzzlePath(folder), "state_*.json"), stateMaxAge)
+	}
+}
+
+// removeOlderThan deletes the files matching pattern that were last
+// modified more than maxAge ago.
+func removeOlderThan(pattern string, maxAge time.Duration) {
+	matches, _ := filepath.Glob(pattern)
+	cutoff := time.Now().Add(-maxAge)
+	for _, path := range matches {
+		info, err := os.Stat(path)
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			log.Printf("Cleanup: failed to remove %s: %v", path, err)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	collabMaxMessage = 4096
+	collabSendBuffer = 32
+	collabMaxIDLen   = 64
+)
+
+// CollabMessage is a board change shared between /ws clients, e.g.
+//
+//	{"type":"place","puzzle":"foo","pos":"2,3","file":"image_0007.png","tabId":"t1","timestamp":1700000000000}
+//
+// The server sets player and tabId to the sending connection's, so a tab
+// can tell its own moves from those of the player's other tabs. Timestamp
+// is in Unix milliseconds and filled in by the server when left out.
+type CollabMessage struct {
+	Type      string `json:"type"`
+	Puzzle    string `json:"puzzle"`
+	Pos       string `json:"pos,omitempty"`
+	File      string `json:"file,omitempty"`
+	Player    string `json:"player,omitempty"`
+	TabID     string `json:"tabId"`
+	Timestamp int64  `json:"timestamp,omitempty"`
+}
+
+// collabClient is a /ws connection: one tab of a player. Only its
+// writePump writes to conn, so the hub hands it messages through send.
+type collabClient struct {
+	conn   *websocket.Conn
+	room   string // puzzle path, unique across tenants
+	player string
+	tab    string
+	send   chan []byte
+}
+
+type collabBroadcast struct {
+	sender *collabClient
+	msg    CollabMessage
+	data   []byte
+}
+
+// collabRoom holds the clients viewing one puzzle, by player and tab, and
+// the time of each player's latest move of every position and tile.
+type collabRoom struct {
+	players map[string]map[string]*collabClient
+	moves   map[string]map[string]int64
+}
+
+// fresh records a move and reports whether it is the most recent its player
+// made of its position and tile. Two tabs of a player can send conflicting
+// moves; the later one by timestamp wins whatever order they arrive in.
+func (room *collabRoom) fresh(msg CollabMessage) bool {
+	var targets []string
+	if msg.Pos != "" {
+		targets = append(targets, "pos:"+msg.Pos)
+	}
+	if msg.File != "" {
+		targets = append(targets, "file:"+msg.File)
+	}
+	if len(targets) == 0 {
+		return true
+	}
+
+	moves := room.moves[msg.Player]
+	if moves == nil {
+		moves = make(map[string]int64)
+		room.moves[msg.Player] = moves
+	}
+	for _, target := range targets {
+		if moves[target] > msg.Timestamp {
+			return false
+		}
+	}
+	for _, target := range targets {
+		moves[target] = msg.Timestamp
+	}
+	return true
+}
+
+// Hub relays messages between the clients viewing the same puzzle. All of
+// its state is owned by the run goroutine.
+type Hub struct {
+	register   chan *collabClient
+	unregister chan *collabClient
+	broadcast  chan collabBroadcast
+	rooms      map[string]*collabRoom
+}
+
+func newHub() *Hub {
+	return &Hub{
+		register:   make(chan *collabClient),
+		unregister: make(chan *collabClient),
+		broadcast:  make(chan collabBroadcast),
+		rooms:      make(map[string]*collabRoom),
+	}
+}
+
+var collabHub = newHub()
+
+func (h *Hub) run() {
+	for {
+		select {
+		case client := <-h.register:
+			h.add(client)
+		case client := <-h.unregister:
+			h.remove(client)
+		case msg := <-h.broadcast:
+			room := h.rooms[msg.sender.room]
+			if room == nil || room.players[msg.sender.player][msg.sender.tab] != msg.sender || !room.fresh(msg.msg) {
+				continue
+			}
+			for _, tabs := range room.players {
+				for _, client := range tabs {
+					if client == msg.sender {
+						continue
+					}
+					select {
+					case client.send <- msg.data:
+					default:
+						// Too slow to keep up; drop it rather than stall the room
+						h.remove(client)
+					}
+				}
+			}
+		}
+	}
+}
+
+// add puts a client in its room. A tab that reconnects replaces its old
+// connection, which may not have noticed it is gone yet.
+func (h *Hub) add(client *collabClient) {
+	room := h.rooms[client.room]
+	if room == nil {
+		room = &collabRoom{
+			players: make(map[string]map[string]*collabClient),
+			moves:   make(map[string]map[string]int64),
+		}
+		h.rooms[client.room] = room
+	}
+	tabs := room.players[client.player]
+	if tabs == nil {
+		tabs = make(map[string]*collabClient)
+		room.players[client.player] = tabs
+	}
+	if old := tabs[client.tab]; old != nil {
+		close(old.send)
+	}
+	tabs[client.tab] = client
+}
+
+// remove forgets a client's tab and closes its send channel, which stops
+// its writePump. The player stays in the room while they have other tabs
+// open. It is safe to call more than once.
+func (h *Hub) remove(client *collabClient) {
+	room := h.rooms[client.room]
+	if room == nil || room.players[client.player][client.tab] != client {
+		return
+	}
+	tabs := room.players[client.player]
+	delete(tabs, client.tab)
+	if len(tabs) == 0 {
+		delete(room.players, client.player)
+		delete(room.moves, client.player)
+	}
+	if len(room.players) == 0 {
+		delete(h.rooms, client.room)
+	}
+	close(client.send)
+}
+
+func (c *collabClient) writePump() {
+	defer c.conn.Close()
+	for data := range c.send {
+		if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+			return
+		}
+	}
+	c.conn.WriteMessage(websocket.CloseMessage, nil)
+}
+
+// validCollabID reports whether id can name a player or tab: a short
+// string of letters, digits, '-' and '_', such as a UUID.
+func validCollabID(id string) bool {
+	if id == "" || len(id) > collabMaxIDLen {
+		return false
+	}
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// collabIDParam returns the named query parameter, or a random ID for a
+// client that did not give one.
+func collabIDParam(r *http.Request, name string) (string, bool) {
+	id := r.URL.Query().Get(name)
+	if id == "" {
+		var b [8]byte
+		rand.Read(b[:])
+		return hex.EncodeToString(b[:]), true
+	}
+	return id, validCollabID(id)
+}
+
+// wsHandler serves /ws?folder=<name>&player=<id>&tabId=<id>, relaying each
+// board change a client sends to the other clients of the same puzzle,
+// including the player's other tabs. Messages for another puzzle are
+// dropped.
+func wsHandler(w http.ResponseWriter, r *http.Request) {
+	folder := r.URL.Query().Get("folder")
+	if !validFolderName(folder) {
+		http.Error(w, "Invalid folder name", http.StatusBadRequest)
+		return
+	}
+	player, ok := collabIDParam(r, "player")
+	if !ok {
+		http.Error(w, "Invalid player ID", http.StatusBadRequest)
+		return
+	}
+	tab, ok := collabIDParam(r, "tabId")
+	if !ok {
+		http.Error(w, "Invalid tab ID", http.StatusBadRequest)
+		return
+	}
+	st := storeFor(r)
+	if _, err := st.loadManifest(folder); err != nil {
+		http.Error(w, "Error reading manifest.json: "+err.Error(), http.StatusNotFound)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return // Upgrade has already replied
+	}
+	conn.SetReadLimit(collabMaxMessage)
+	client := &collabClient{
+		conn:   conn,
+		room:   st.puzzlePath(folder),
+		player: player,
+		tab:    tab,
+		send:   make(chan []byte, collabSendBuffer),
+	}
+	collabHub.register <- client
+	go client.writePump()
+	defer func() { collabHub.unregister <- client }()
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var msg CollabMessage
+		if err := json.Unmarshal(data, &msg); err != nil || msg.Type == "" {
+			log.Printf("WARNING: Ignoring invalid /ws message for %s", folder)
+			continue
+		}
+		if msg.Puzzle != folder {
+			continue
+		}
+		msg.Player, msg.TabID = player, tab
+		if msg.Timestamp == 0 {
+			msg.Timestamp = time.Now().UnixMilli()
+		}
+		data, err = json.Marshal(msg)
+		if err != nil {
+			continue
+		}
+		collabHub.broadcast <- collabBroadcast{sender: client, msg: msg, data: data}
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var startHub sync.Once
+
+// newCollabServer serves the API, with the collaboration hub running and a
+// puzzle for each folder, and returns its ws:// address.
+func newCollabServer(t *testing.T, folders ...string) string {
+	t.Helper()
+	startHub.Do(func() { go collabHub.run() })
+	mux, _ := newTestMux(t)
+	for i, folder := range folders {
+		mustUpload(t, mux, encodePNG(t, testImage(128, 128, i+1)), map[string]string{
+			"name": folder, "columns": "2", "tileSize": "64",
+		})
+	}
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+// testCollabClient is a /ws connection whose messages are read into recv.
+// gorilla/websocket connections cannot be read again after a read times
+// out, so the tests wait on the channel instead.
+type testCollabClient struct {
+	conn *websocket.Conn
+	recv chan CollabMessage
+}
+
+func dialCollab(t *testing.T, addr, folder string) *testCollabClient {
+	t.Helper()
+	return dialCollabTab(t, addr, folder, "", "")
+}
+
+// dialCollabTab connects as a tab of a player; empty IDs are left for the
+// server to pick.
+func dialCollabTab(t *testing.T, addr, folder, player, tab string) *testCollabClient {
+	t.Helper()
+	q := url.Values{"folder": {folder}}
+	if player != "" {
+		q.Set("player", player)
+	}
+	if tab != "" {
+		q.Set("tabId", tab)
+	}
+	conn, _, err := websocket.DefaultDialer.Dial(addr+"/ws?"+q.Encode(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &testCollabClient{conn: conn, recv: make(chan CollabMessage, 100)}
+	go func() {
+		defer close(c.recv)
+		for {
+			var msg CollabMessage
+			if err := conn.ReadJSON(&msg); err != nil {
+				return
+			}
+			c.recv <- msg
+		}
+	}()
+	t.Cleanup(func() { conn.Close() })
+	return c
+}
+
+func (c *testCollabClient) send(t *testing.T, msg CollabMessage) {
+	t.Helper()
+	if err := c.conn.WriteJSON(msg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// next returns the next message of type typ, skipping others, or fails
+// the test after timeout.
+func (c *testCollabClient) next(t *testing.T, typ string, timeout time.Duration) CollabMessage {
+	t.Helper()
+	deadline := time.After(timeout)
+	for {
+		select {
+		case msg, ok := <-c.recv:
+			if !ok {
+				t.Fatal("connection closed")
+			}
+			if msg.Type == typ {
+				return msg
+			}
+		case <-deadline:
+			t.Fatalf("no %q message within %v", typ, timeout)
+		}
+	}
+}
+
+// joined waits until from and to are both in the hub's room: the hub only
+// relays messages of registered clients to the clients registered so far,
+// and the server registers a client after the client's Dial returns.
+func joined(t *testing.T, from, to *testCollabClient, folder string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		from.send(t, CollabMessage{Type: "ping", Puzzle: folder})
+		select {
+		case <-to.recv:
+			return
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+	t.Fatal("clients never joined the same room")
+}
+
+func TestCollabBroadcast(t *testing.T) {
+	addr := newCollabServer(t, "foo", "bar")
+	a, b := dialCollab(t, addr, "foo"), dialCollab(t, addr, "foo")
+	other := dialCollab(t, addr, "bar")
+	joined(t, a, b, "foo")
+
+	place := CollabMessage{Type: "place", Puzzle: "foo", Pos: "2,3", File: "image_0007.png"}
+	a.send(t, place)
+	if got := b.next(t, "place", 2*time.Second); !sameMove(got, place) {
+		t.Errorf("got %+v, want %+v", got, place)
+	}
+
+	// Neither the sender nor another puzzle's clients hear it
+	select {
+	case msg := <-a.recv:
+		t.Errorf("sender received %+v", msg)
+	case msg := <-other.recv:
+		t.Errorf("client of another puzzle received %+v", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// A client leaving does not break the room for the others
+	c := dialCollab(t, addr, "foo")
+	joined(t, c, b, "foo")
+	a.conn.Close()
+	c.send(t, place)
+	if got := b.next(t, "place", 2*time.Second); !sameMove(got, place) {
+		t.Errorf("after a client left: got %+v, want %+v", got, place)
+	}
+}
+
+// sameMove compares the move of two messages, ignoring who made it and
+// when.
+func sameMove(a, b CollabMessage) bool {
+	return a.Type == b.Type && a.Puzzle == b.Puzzle && a.Pos == b.Pos && a.File == b.File
+}
+
+func TestCollabTabs(t *testing.T) {
+	addr := newCollabServer(t, "foo")
+	tab1 := dialCollabTab(t, addr, "foo", "alice", "tab1")
+	tab2 := dialCollabTab(t, addr, "foo", "alice", "tab2")
+	bob := dialCollabTab(t, addr, "foo", "bob", "tab1")
+	joined(t, tab1, bob, "foo")
+	joined(t, tab2, bob, "foo")
+	joined(t, bob, tab1, "foo")
+	joined(t, bob, tab2, "foo")
+	for _, c := range []*testCollabClient{tab1, tab2, bob} {
+		drain(c)
+	}
+
+	t.Run("tabId", func(t *testing.T) {
+		// The sender's identity is the connection's, whatever the message says
+		tab1.send(t, CollabMessage{Type: "place", Puzzle: "foo", Pos: "0,0", File: "a.png", Player: "bob", TabID: "tab9", Timestamp: 100})
+		for _, c := range []*testCollabClient{tab2, bob} {
+			got := c.next(t, "place", 2*time.Second)
+			if got.Player != "alice" || got.TabID != "tab1" || got.Timestamp != 100 {
+				t.Errorf("got %+v, want player alice, tabId tab1, timestamp 100", got)
+			}
+		}
+	})
+
+	t.Run("latest move wins", func(t *testing.T) {
+		tab2.send(t, CollabMessage{Type: "place", Puzzle: "foo", Pos: "1,1", File: "b.png", Timestamp: 300})
+		if got := bob.next(t, "place", 2*time.Second); got.Timestamp != 300 {
+			t.Fatalf("got %+v, want the move at 300", got)
+		}
+		if got := tab1.next(t, "place", 2*time.Second); got.TabID != "tab2" {
+			t.Fatalf("got %+v, want tab2's move", got)
+		}
+		// tab1 made its move of 1,1 earlier but it arrives later
+		tab1.send(t, CollabMessage{Type: "place", Puzzle: "foo", Pos: "1,1", File: "c.png", Timestamp: 200})
+		tab1.send(t, CollabMessage{Type: "marker", Puzzle: "foo"})
+		if got := <-bob.recv; got.Type != "marker" {
+			t.Errorf("stale move relayed: %+v", got)
+		}
+		// Another player's moves are not compared with alice's
+		bob.send(t, CollabMessage{Type: "place", Puzzle: "foo", Pos: "1,1", File: "d.png", Timestamp: 150})
+		if got := tab1.next(t, "place", 2*time.Second); got.File != "d.png" {
+			t.Errorf("got %+v, want bob's move", got)
+		}
+		drain(tab2)
+	})
+
+	t.Run("closing a tab", func(t *testing.T) {
+		tab1.conn.Close()
+		move := CollabMessage{Type: "place", Puzzle: "foo", Pos: "2,2", File: "e.png"}
+		bob.send(t, move)
+		if got := tab2.next(t, "place", 2*time.Second); !sameMove(got, move) {
+			t.Errorf("got %+v, want %+v", got, move)
+		}
+		// alice's moves history survives while she has a tab open
+		tab2.send(t, CollabMessage{Type: "place", Puzzle: "foo", Pos: "1,1", File: "f.png", Timestamp: 250})
+		tab2.send(t, CollabMessage{Type: "marker", Puzzle: "foo"})
+		if got := <-bob.recv; got.Type != "marker" {
+			t.Errorf("stale move relayed: %+v", got)
+		}
+	})
+}
+
+// drain discards the messages a client has received so far.
+func drain(c *testCollabClient) {
+	for {
+		select {
+		case <-c.recv:
+		case <-time.After(50 * time.Millisecond):
+			return
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/nfnt/resize"
+)
+
+const (
+	collageCellSize    = 512
+	collageMaxImages   = 36
+	collageMaxDownload = 20 << 20 // 20 MB per image
+	collageMaxPixels   = 40 << 20 // about 40 megapixels per image
+)
+
+// collageClient fetches collage images. It connects to public addresses
+// only, with no proxy, so a collage cannot be used to probe the server's
+// own network; redirects are checked the same way.
+var collageClient = &http.Client{
+	Timeout:   30 * time.Second,
+	Transport: &http.Transport{DialContext: collageDialContext},
+}
+
+// publicIP reports whether ip can be fetched from: loopback, private,
+// link-local, multicast and unspecified addresses cannot.
+func publicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified())
+}
+
+// collageDialContext resolves the host itself and dials one of its public
+// addresses, so the address checked is the address connected to.
+func collageDialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	var dialer net.Dialer
+	for _, a := range addrs {
+		if !publicIP(a.IP) {
+			continue
+		}
+		conn, dialErr := dialer.DialContext(ctx, network, net.JoinHostPort(a.IP.String(), port))
+		if dialErr == nil {
+			return conn, nil
+		}
+		err = dialErr
+	}
+	if err == nil {
+		err = fmt.Errorf("%s has no public address", host)
+	}
+	return nil, err
+}
+
+// CollageRequest is the body of POST /createCollage.
+type CollageRequest struct {
+	Name     string   `json:"name"`
+	URLs     []string `json:"urls"`
+	GridCols int      `json:"gridCols"`
+	GridRows int      `json:"gridRows"`
+	Columns  int      `json:"columns,omitempty"` // puzzle columns; defaults to 2×gridCols
+}
+
+// fetchImage downloads and decodes the image at rawURL. Its dimensions are
+// checked before it is decoded, since a small file can declare a huge image.
+func fetchImage(rawURL string) (image.Image, error) {
+	resp, err := collageClient.Get(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, collageMaxDownload+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > collageMaxDownload {
+		return nil, fmt.Errorf("larger than %d MB", collageMaxDownload>>20)
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || int64(cfg.Width)*int64(cfg.Height) > collageMaxPixels {
+		return nil, fmt.Errorf("image of %dx%d pixels is too large", cfg.Width, cfg.Height)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	return img, err
+}
+
+// buildCollage arranges imgs row by row into a cols×rows grid of equally
+// sized cells. Each image is scaled to cover its cell and centre-cropped;
+// cells without an image stay white.
+func buildCollage(imgs []image.Image, cols, rows int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, cols*collageCellSize, rows*collageCellSize))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	for i, img := range imgs {
+		b := img.Bounds()
+		scale := max(float64(collageCellSize)/float64(b.Dx()), float64(collageCellSize)/float64(b.Dy()))
+		scaled := resize.Resize(uint(float64(b.Dx())*scale+0.5), uint(float64(b.Dy())*scale+0.5), img, resize.Lanczos3)
+		cell := cropToFill(scaled, collageCellSize, collageCellSize)
+
+		x0 := (i % cols) * collageCellSize
+		y0 := (i / cols) * collageCellSize
+		draw.Draw(dst, image.Rect(x0, y0, x0+collageCellSize, y0+collageCellSize), cell, image.Point{}, draw.Src)
+	}
+	return dst
+}
+
+func createCollageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req CollageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Name == "" {
+		http.Error(w, "Puzzle name is required", http.StatusBadRequest)
+		return
+	}
+	if req.GridCols <= 0 || req.GridRows <= 0 || req.GridCols*req.GridRows > collageMaxImages {
+		http.Error(w, fmt.Sprintf("Invalid grid: gridCols×gridRows must be between 1 and %d", collageMaxImages), http.StatusBadRequest)
+		return
+	}
+	if len(req.URLs) == 0 || len(req.URLs) > req.GridCols*req.GridRows {
+		http.Error(w, "urls must contain between 1 and gridCols×gridRows entries", http.StatusBadRequest)
+		return
+	}
+	for _, rawURL := range req.URLs {
+		if u, err := url.Parse(rawURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			http.Error(w, "Invalid URL "+rawURL+": only http and https are allowed", http.StatusBadRequest)
+			return
+		}
+	}
+	if req.Columns == 0 {
+		req.Columns = 2 * req.GridCols
+	}
+	if req.Columns < 0 {
+		http.Error(w, "Invalid number of columns", http.StatusBadRequest)
+		return
+	}
+
+	// Download all images concurrently
+	imgs := make([]image.Image, len(req.URLs))
+	errs := make([]error, len(req.URLs))
+	var wg sync.WaitGroup
+	for i, u := range req.URLs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			imgs[i], errs[i] = fetchImage(u)
+		}()
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error fetching %s: %v", req.URLs[i], err), http.StatusBadGateway)
+			return
+		}
+	}
+
+	opts := defaultPuzzleOptions(r)
+	opts.Name = req.Name
+	opts.Columns = req.Columns
+
+	createPuzzle(w, r, buildCollage(imgs, req.GridCols, req.GridRows), opts)
+}
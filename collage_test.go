@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestFetchImageRejectsPrivateAddresses(t *testing.T) {
+	png := encodePNG(t, testImage(16, 16, 1))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(png)
+	}))
+	defer srv.Close()
+	addr, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, u := range []string{
+		srv.URL, // loopback
+		"http://localhost:" + addr.Port(),
+		"http://10.0.0.1/a.png",
+		"http://192.168.1.1/a.png",
+		"http://169.254.169.254/latest/meta-data",
+		"http://[::1]/a.png",
+		"http://[fe80::1]/a.png",
+		"http://0.0.0.0/a.png",
+	} {
+		if _, err := fetchImage(u); err == nil || !strings.Contains(err.Error(), "no public address") {
+			t.Errorf("%s: %v, want no public address", u, err)
+		}
+	}
+}
+
+func TestFetchImageChecksDimensions(t *testing.T) {
+	small := encodePNG(t, testImage(16, 16, 1))
+	// A PNG whose header claims far more pixels than its few bytes hold
+	huge := append([]byte(nil), small...)
+	binary.BigEndian.PutUint32(huge[16:], 100000)
+	binary.BigEndian.PutUint32(huge[20:], 100000)
+	binary.BigEndian.PutUint32(huge[29:], crc32.ChecksumIEEE(huge[12:29]))
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/huge.png" {
+			w.Write(huge)
+			return
+		}
+		w.Write(small)
+	}))
+	defer srv.Close()
+	// The test server is on loopback, which collageClient refuses
+	setFlag(t, &collageClient.Transport, srv.Client().Transport)
+
+	img, err := fetchImage(srv.URL + "/small.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 16 || b.Dy() != 16 {
+		t.Errorf("decoded %v, want 16x16", b)
+	}
+
+	if _, err := fetchImage(srv.URL + "/huge.png"); err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Errorf("huge image: %v, want a too large error", err)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxPlayerNameLength  = 50
+	completionsListLimit = 100
+)
+
+// completionsMutex serialises appends to the completions.log files.
+var completionsMutex sync.Mutex
+
+type CompletionRequest struct {
+	Folder      string `json:"folder"`
+	PlayerName  string `json:"playerName"`
+	DurationSec int    `json:"durationSec"`
+}
+
+// CompletionRecord is one line of images/<folder>/completions.log.
+type CompletionRecord struct {
+	PlayerName  string    `json:"playerName"`
+	DurationSec int       `json:"durationSec"`
+	CompletedAt time.Time `json:"completedAt"`
+}
+
+func (st *store) completionsPath(folder string) string {
+	return filepath.Join(st.puzzlePath(folder), "completions.log")
+}
+
+// sanitizePlayerName keeps printable ASCII only and limits the length.
+func sanitizePlayerName(name string) string {
+	var sb strings.Builder
+	for _, r := range name {
+		if r >= 0x20 && r <= 0x7e {
+			sb.WriteRune(r)
+		}
+	}
+	name = strings.TrimSpace(sb.String())
+	if len(name) > maxPlayerNameLength {
+		name = strings.TrimSpace(name[:maxPlayerNameLength])
+	}
+	if name == "" {
+		name = "Anonymous"
+	}
+	return name
+}
+
+// loadCompletions reads every completion recorded for a puzzle, oldest
+// first. A puzzle that has never been completed has no log file.
+func (st *store) loadCompletions(folder string) ([]CompletionRecord, error) {
+	file, err := os.Open(st.completionsPath(folder))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var records []CompletionRecord
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record CompletionRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			continue // skip damaged lines
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+func flagCompleteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req CompletionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !validFolderName(req.Folder) {
+		http.Error(w, "Invalid folder name", http.StatusBadRequest)
+		return
+	}
+	if !requirePuzzleVisible(w, r, req.Folder) {
+		return
+	}
+	if req.DurationSec < 0 {
+		http.Error(w, "durationSec must not be negative", http.StatusBadRequest)
+		return
+	}
+	st := storeFor(r)
+	if _, err := os.Stat(filepath.Join(st.puzzlePath(req.Folder), "manifest.json")); err != nil {
+		http.Error(w, "Puzzle not found", http.StatusNotFound)
+		return
+	}
+
+	record := CompletionRecord{
+		PlayerName:  sanitizePlayerName(req.PlayerName),
+		DurationSec: req.DurationSec,
+		CompletedAt: time.Now().UTC(),
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		http.Error(w, "Error encoding completion: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	completionsMutex.Lock()
+	file, err := os.OpenFile(st.completionsPath(req.Folder), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err == nil {
+		_, err = file.Write(append(line, '\n'))
+		file.Close()
+	}
+	completionsMutex.Unlock()
+	if err != nil {
+		http.Error(w, "Error writing completions.log: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	go broadcastLeaderboard(st, req.Folder, record)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"status": "ok",
+		"_links": buildLinks(req.Folder, r),
+	})
+}
+
+// completionsHandler returns the most recent completions of a puzzle, fastest
+// first. Player names are only included for admin requests.
+func completionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	folder := r.URL.Query().Get("folder")
+	if !validFolderName(folder) {
+		http.Error(w, "Invalid folder name", http.StatusBadRequest)
+		return
+	}
+
+	records, err := storeFor(r).loadCompletions(folder)
+	if err != nil {
+		http.Error(w, "Error reading completions.log: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(records) > completionsListLimit {
+		records = records[len(records)-completionsListLimit:]
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].DurationSec < records[j].DurationSec
+	})
+
+	if !isAdmin(r) {
+		records = anonymizeCompletions(records)
+	}
+	if records == nil {
+		records = []CompletionRecord{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(records)
+}
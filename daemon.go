@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// isDaemonFlag reports whether a command line argument is -daemon, in any
+// of the spellings the flag package accepts.
+func isDaemonFlag(arg string) bool {
+	name, _, _ := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+	return strings.HasPrefix(arg, "-") && name == "daemon"
+}
+
+// daemonize starts the server again in the background with the same
+// arguments minus -daemon and writes the child's PID to pidFile. The
+// caller exits afterwards.
+func daemonize(pidFile string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	args := []string{os.Args[0]}
+	for _, arg := range os.Args[1:] {
+		if !isDaemonFlag(arg) {
+			args = append(args, arg)
+		}
+	}
+
+	devNull, err := os.Open(os.DevNull)
+	if err != nil {
+		return err
+	}
+	defer devNull.Close()
+	proc, err := os.StartProcess(exe, args, &os.ProcAttr{
+		Files: []*os.File{devNull, os.Stdout, os.Stderr},
+	})
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(pidFile, []byte(strconv.Itoa(proc.Pid)+"\n"), 0644); err != nil {
+		proc.Kill()
+		return fmt.Errorf("writing %s: %v", pidFile, err)
+	}
+	fmt.Printf("TilePuzzler running in the background with PID %d\n", proc.Pid)
+	return proc.Release()
+}
+
+// stopDaemon sends SIGTERM to the server whose PID is in pidFile and
+// removes the file.
+func stopDaemon(pidFile string) error {
+	data, err := os.ReadFile(pidFile)
+	if err != nil {
+		return err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return fmt.Errorf("%s does not contain a PID", pidFile)
+	}
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	if err := proc.Signal(syscall.SIGTERM); err != nil {
+		return fmt.Errorf("stopping PID %d: %v", pid, err)
+	}
+	fmt.Printf("Sent SIGTERM to PID %d\n", pid)
+	return os.Remove(pidFile)
+}
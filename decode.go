@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+
+	"golang.org/x/image/tiff"
+	"golang.org/x/image/webp"
+)
+
+// uploadDecoders are the decoders of the image formats accepted for
+// uploads, keyed by MIME type.
+var uploadDecoders = map[string]func(io.Reader) (image.Image, error){
+	"image/jpeg": jpeg.Decode,
+	"image/png":  png.Decode,
+	"image/gif":  gif.Decode,
+	"image/webp": webp.Decode,
+	"image/tiff": tiff.Decode,
+}
+
+// sniffImageType returns the MIME type of an image from its first bytes.
+// http.DetectContentType does not know TIFF, so its magic numbers are
+// checked here.
+func sniffImageType(head []byte) string {
+	if bytes.HasPrefix(head, []byte("II*\x00")) || bytes.HasPrefix(head, []byte("MM\x00*")) {
+		return "image/tiff"
+	}
+	return http.DetectContentType(head)
+}
+
+// decodeUpload decodes an uploaded image with the decoder its content
+// calls for. Unsupported formats are a 415.
+func decodeUpload(r io.Reader) (image.Image, error) {
+	// Peek so that the decoder still sees the magic bytes
+	br := bufio.NewReaderSize(r, 512)
+	head, _ := br.Peek(512)
+	decode, ok := uploadDecoders[sniffImageType(head)]
+	if !ok {
+		return nil, &statusError{http.StatusUnsupportedMediaType, "Unsupported image format: must be JPEG, PNG, GIF, WebP or TIFF"}
+	}
+	img, err := decode(br)
+	if err != nil {
+		return nil, &statusError{http.StatusBadRequest, "Error decoding image: " + err.Error()}
+	}
+	return img, nil
+}
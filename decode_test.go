@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"net/http"
+	"testing"
+
+	"golang.org/x/image/tiff"
+)
+
+// bigEndianTIFF encodes img as an uncompressed, Motorola byte order RGB
+// TIFF, which golang.org/x/image/tiff cannot write.
+func bigEndianTIFF(img image.Image) []byte {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	const entries = 9
+	ifdSize := 2 + entries*12 + 4
+	bitsOffset := 8 + ifdSize
+	pixelsOffset := bitsOffset + 6
+
+	var buf bytes.Buffer
+	put := func(v any) { binary.Write(&buf, binary.BigEndian, v) }
+	buf.WriteString("MM\x00*")
+	put(uint32(8))
+	put(uint16(entries))
+	entry := func(tag, typ uint16, count, value uint32) {
+		put(tag)
+		put(typ)
+		put(count)
+		if typ == 3 && count == 1 {
+			put(uint16(value)) // a SHORT sits in the first half of the field
+			put(uint16(0))
+		} else {
+			put(value)
+		}
+	}
+	entry(256, 4, 1, uint32(w))            // ImageWidth
+	entry(257, 4, 1, uint32(h))            // ImageLength
+	entry(258, 3, 3, uint32(bitsOffset))   // BitsPerSample
+	entry(259, 3, 1, 1)                    // Compression: none
+	entry(262, 3, 1, 2)                    // PhotometricInterpretation: RGB
+	entry(273, 4, 1, uint32(pixelsOffset)) // StripOffsets
+	entry(277, 3, 1, 3)                    // SamplesPerPixel
+	entry(278, 4, 1, uint32(h))            // RowsPerStrip
+	entry(279, 4, 1, uint32(w*h*3))        // StripByteCounts
+	put(uint32(0))
+	put([]uint16{8, 8, 8})
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+			buf.Write([]byte{c.R, c.G, c.B})
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestDecodeUpload(t *testing.T) {
+	img := testImage(32, 24, 1)
+	encode := func(f func(*bytes.Buffer) error) []byte {
+		var buf bytes.Buffer
+		if err := f(&buf); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	tests := []struct {
+		name     string
+		data     []byte
+		status   int  // of the error; 0 for success
+		lossless bool // the decoded pixels must match img
+	}{
+		{"png", encodePNG(t, img), 0, true},
+		{"jpeg", encode(func(w *bytes.Buffer) error { return jpeg.Encode(w, img, nil) }), 0, false},
+		{"gif", encode(func(w *bytes.Buffer) error { return gif.Encode(w, img, nil) }), 0, false},
+		{"webp", encode(func(w *bytes.Buffer) error { return exportFormat{Name: "webp", Quality: 100}.encode(w, img) }), 0, false},
+		{"tiff little-endian", encode(func(w *bytes.Buffer) error { return tiff.Encode(w, img, nil) }), 0, true},
+		{"tiff big-endian", bigEndianTIFF(img), 0, true},
+		{"unknown format", []byte("BM this is not an image we take\x00\x01\x02"), http.StatusUnsupportedMediaType, false},
+		{"text", []byte("hello, world"), http.StatusUnsupportedMediaType, false},
+		{"corrupt png", encodePNG(t, img)[:64], http.StatusBadRequest, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeUpload(bytes.NewReader(tt.data))
+			if tt.status != 0 {
+				var se *statusError
+				if !errors.As(err, &se) || se.status != tt.status {
+					t.Fatalf("error %v, want status %d", err, tt.status)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Bounds().Size() != img.Bounds().Size() {
+				t.Fatalf("size %v, want %v", got.Bounds().Size(), img.Bounds().Size())
+			}
+			if tt.lossless {
+				for _, p := range []image.Point{{0, 0}, {31, 0}, {5, 17}, {31, 23}} {
+					want := color.RGBAModel.Convert(img.At(p.X, p.Y))
+					if c := color.RGBAModel.Convert(got.At(p.X, p.Y)); c != want {
+						t.Errorf("pixel %v is %v, want %v", p, c, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestUploadUnsupportedFormat(t *testing.T) {
+	mux, _ := newTestMux(t)
+	rec := uploadTestPuzzle(t, mux, []byte("BM not a bitmap at all"), map[string]string{"name": "foo", "columns": "2"})
+	if rec.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("status %d, want 415: %s", rec.Code, rec.Body)
+	}
+}
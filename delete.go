@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// trashPath returns where a soft-deleted puzzle folder is kept. The trash
+// is a dot directory, so it is never listed as a puzzle.
+func (st *store) trashPath(folder string) string {
+	return filepath.Join(st.root, ".trash", folder)
+}
+
+// findEntryIndex returns the position in imageIndex of the entry for folder
+// with the given deleted state, or -1.
+func findEntryIndex(imageIndex ImageIndex, folder string, deleted bool) int {
+	for i, entry := range imageIndex.Images {
+		if entry.Folder == folder && entry.Deleted == deleted {
+			return i
+		}
+	}
+	return -1
+}
+
+// deletePuzzle removes a puzzle folder and its imageIndex.json entry. With
+// soft set the folder is moved to the trash and the entry only marked as
+// deleted. Hard-deleting a soft-deleted puzzle empties it from the trash.
+func (st *store) deletePuzzle(folder string, soft bool) error {
+	defer st.lockFolder(folder)()
+	manifestMutex.Lock()
+	defer manifestMutex.Unlock()
+	imageIndexMutex.Lock()
+	defer imageIndexMutex.Unlock()
+
+	imageIndex, err := st.loadImageIndex()
+	if err != nil {
+		return &statusError{http.StatusInternalServerError, "Error reading imageIndex.json: " + err.Error()}
+	}
+
+	puzzlePath := st.puzzlePath(folder)
+	i := findEntryIndex(imageIndex, folder, false)
+	if _, err := os.Stat(puzzlePath); err != nil {
+		if soft {
+			return &statusError{http.StatusNotFound, "Puzzle not found"}
+		}
+		// Not live; perhaps it is in the trash
+		if j := findEntryIndex(imageIndex, folder, true); j >= 0 {
+			i, puzzlePath = j, st.trashPath(folder)
+		} else if i < 0 {
+			return &statusError{http.StatusNotFound, "Puzzle not found"}
+		}
+	}
+
+	if soft {
+		trashPath := st.trashPath(folder)
+		if _, err := os.Stat(trashPath); err == nil {
+			return &statusError{http.StatusConflict, "A deleted puzzle with that folder name is already in the trash"}
+		}
+		if err := os.MkdirAll(filepath.Dir(trashPath), 0755); err != nil {
+			return &statusError{http.StatusInternalServerError, "Error creating trash directory: " + err.Error()}
+		}
+		if err := os.Rename(puzzlePath, trashPath); err != nil {
+			return &statusError{http.StatusInternalServerError, "Error moving puzzle to the trash: " + err.Error()}
+		}
+		if i >= 0 {
+			now := time.Now().UTC()
+			imageIndex.Images[i].Deleted = true
+			imageIndex.Images[i].DeletedAt = &now
+		}
+		if i >= 0 {
+			if err := st.saveImageIndex(imageIndex); err != nil {
+				return &statusError{http.StatusInternalServerError, "Error writing imageIndex.json: " + err.Error()}
+			}
+		}
+	} else {
+		// Unlist the puzzle before removing it; a folder left behind by a
+		// failed removal is only an orphan
+		if i >= 0 {
+			imageIndex.Images = append(imageIndex.Images[:i], imageIndex.Images[i+1:]...)
+			if err := st.saveImageIndex(imageIndex); err != nil {
+				return &statusError{http.StatusInternalServerError, "Error writing imageIndex.json: " + err.Error()}
+			}
+		}
+		if err := os.RemoveAll(puzzlePath); err != nil {
+			return &statusError{http.StatusInternalServerError, "Error deleting puzzle: " + err.Error()}
+		}
+	}
+	st.invalidateExportCache(folder)
+	return nil
+}
+
+// restorePuzzle moves a soft-deleted puzzle back out of the trash.
+func (st *store) restorePuzzle(folder string) error {
+	defer st.lockFolder(folder)()
+	manifestMutex.Lock()
+	defer manifestMutex.Unlock()
+	imageIndexMutex.Lock()
+	defer imageIndexMutex.Unlock()
+
+	imageIndex, err := st.loadImageIndex()
+	if err != nil {
+		return &statusError{http.StatusInternalServerError, "Error reading imageIndex.json: " + err.Error()}
+	}
+	i := findEntryIndex(imageIndex, folder, true)
+	trashPath := st.trashPath(folder)
+	if _, err := os.Stat(trashPath); i < 0 || err != nil {
+		return &statusError{http.StatusNotFound, "No deleted puzzle with that folder name"}
+	}
+
+	puzzlePath := st.puzzlePath(folder)
+	if _, err := os.Stat(puzzlePath); !errors.Is(err, os.ErrNotExist) {
+		return &statusError{http.StatusConflict, "A puzzle with that folder name already exists"}
+	}
+	if err := os.MkdirAll(filepath.Dir(puzzlePath), 0755); err != nil {
+		return &statusError{http.StatusInternalServerError, "Error creating puzzle directory: " + err.Error()}
+	}
+	if err := os.Rename(trashPath, puzzlePath); err != nil {
+		return &statusError{http.StatusInternalServerError, "Error restoring puzzle: " + err.Error()}
+	}
+
+	imageIndex.Images[i].Deleted = false
+	imageIndex.Images[i].DeletedAt = nil
+	if err := st.saveImageIndex(imageIndex); err != nil {
+		return &statusError{http.StatusInternalServerError, "Error writing imageIndex.json: " + err.Error()}
+	}
+	return nil
+}
+
+// puzzleHandler serves DELETE /puzzle?folder=<name>[&softDelete=true].
+func puzzleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "DELETE required", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	folder := r.URL.Query().Get("folder")
+	if !validFolderName(folder) {
+		http.Error(w, "Invalid folder name", http.StatusBadRequest)
+		return
+	}
+	soft, err := strconv.ParseBool(formValueOr(r, "softDelete", "false"))
+	if err != nil {
+		http.Error(w, "Invalid softDelete: must be true or false", http.StatusBadRequest)
+		return
+	}
+
+	if err := storeFor(r).deletePuzzle(folder, soft); err != nil {
+		writeStatusError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"status": "ok", "folder": folder, "softDelete": soft})
+}
+
+// deletePuzzleHandler serves DELETE /deletePuzzle?folder=<name>. Unlike
+// DELETE /puzzle it only deletes puzzles listed in imageIndex.json.
+func deletePuzzleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "DELETE required", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	folder := r.URL.Query().Get("folder")
+	if !validFolderName(folder) {
+		http.Error(w, "Invalid folder name", http.StatusBadRequest)
+		return
+	}
+
+	st := storeFor(r)
+	entry, found, err := st.findImageEntry(folder)
+	if err != nil {
+		http.Error(w, "Error reading imageIndex.json: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !found || entry.Deleted {
+		http.Error(w, "Puzzle not found", http.StatusNotFound)
+		return
+	}
+	if err := st.deletePuzzle(folder, false); err != nil {
+		writeStatusError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok", "deleted": folder})
+}
+
+func restorePuzzleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	folder := r.URL.Query().Get("folder")
+	if !validFolderName(folder) {
+		http.Error(w, "Invalid folder name", http.StatusBadRequest)
+		return
+	}
+
+	if err := storeFor(r).restorePuzzle(folder); err != nil {
+		writeStatusError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"status": "ok",
+		"folder": folder,
+		"_links": buildLinks(folder, r),
+	})
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// adminRequest sends a request with the admin token "secret".
+func adminRequest(h http.Handler, method, target string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	req.Header.Set("X-Admin-Token", "secret")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestReuploadAfterSoftDelete(t *testing.T) {
+	mux, st := newTestMux(t)
+	setFlag(t, adminToken, "secret")
+	fields := map[string]string{"name": "foo", "columns": "2", "tileSize": "64"}
+	mustUpload(t, mux, encodePNG(t, testImage(128, 128, 1)), fields)
+	if rec := adminRequest(mux, http.MethodDelete, "/puzzle?folder=foo&softDelete=true"); rec.Code != http.StatusOK {
+		t.Fatalf("soft delete: %d %s", rec.Code, rec.Body)
+	}
+	mustUpload(t, mux, encodePNG(t, testImage(128, 128, 2)), fields)
+
+	// The tombstone comes first in imageIndex.json, the live entry second
+	entry, ok, err := st.findImageEntry("foo")
+	if err != nil || !ok || entry.Deleted {
+		t.Fatalf("findImageEntry: %+v, %v, %v; want the live entry", entry, ok, err)
+	}
+	if _, err := st.updateImageEntry("foo", func(entry *ImageEntry) { entry.Tl = "updated.png" }); err != nil {
+		t.Fatal(err)
+	}
+	imageIndex, err := st.loadImageIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range imageIndex.Images {
+		if entry.Deleted && entry.Tl == "updated.png" {
+			t.Error("update landed on the deleted entry")
+		}
+		if !entry.Deleted && entry.Tl != "updated.png" {
+			t.Error("update missed the live entry")
+		}
+	}
+
+	if rec := adminRequest(mux, http.MethodDelete, "/deletePuzzle?folder=foo"); rec.Code != http.StatusOK {
+		t.Fatalf("deletePuzzle of the live puzzle: %d %s", rec.Code, rec.Body)
+	}
+	if rec := get(mux, "/puzzleMeta?folder=foo"); rec.Code != http.StatusNotFound {
+		t.Errorf("puzzle still served after delete: %d", rec.Code)
+	}
+	// The soft-deleted one can still be restored
+	if rec := adminRequest(mux, http.MethodPost, "/restorePuzzle?folder=foo"); rec.Code != http.StatusOK {
+		t.Errorf("restore: %d %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// downloadPiecesHandler streams a ZIP of a puzzle's tile images. The
+// optional positions parameter ("0,0:1,2") restricts it to those tiles.
+func downloadPiecesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	folder := r.URL.Query().Get("folder")
+	if !validFolderName(folder) {
+		http.Error(w, "Invalid folder name", http.StatusBadRequest)
+		return
+	}
+
+	st := storeFor(r)
+	manifest, err := st.loadManifest(folder)
+	if err != nil {
+		http.Error(w, "Error reading manifest.json: "+err.Error(), http.StatusNotFound)
+		return
+	}
+
+	var files []string
+	if positions := r.URL.Query().Get("positions"); positions != "" {
+		for _, pos := range strings.Split(positions, ":") {
+			file, ok := manifest.Solution[strings.TrimSpace(pos)]
+			if !ok {
+				http.Error(w, fmt.Sprintf("Invalid position %q", pos), http.StatusBadRequest)
+				return
+			}
+			files = append(files, file)
+		}
+	} else {
+		for _, piece := range manifest.Pieces {
+			files = append(files, piece.File)
+		}
+	}
+
+	// No Content-Length is set, so the archive is sent chunked as it is built
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s_pieces.zip"`, folder))
+
+	zw := zip.NewWriter(w)
+	piecesDir := filepath.Join(st.puzzlePath(folder), "pieces")
+	for _, file := range files {
+		if err := addZipFile(zw, filepath.Join(piecesDir, file), file); err != nil {
+			// The response has started, so all we can do is cut it short
+			log.Printf("downloadPieces %s: %v", folder, err)
+			return
+		}
+	}
+	if err := zw.Close(); err != nil {
+		log.Printf("downloadPieces %s: %v", folder, err)
+	}
+}
+
+// addZipFile copies the file at path into zw under name. Tiles are already
+// compressed images, so they are stored rather than deflated.
+func addZipFile(zw *zip.Writer, path, name string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	hdr, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	hdr.Method = zip.Store
+
+	dst, err := zw.CreateHeader(hdr)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, f)
+	return err
+}
+
+type ExportTilesetRequest struct {
+	Folder       string `json:"folder"`
+	NameTemplate string `json:"nameTemplate"` // restricted as for uploads: fields Index, Row, Col and Hash, and printf
+}
+
+// exportTilesetHandler streams a ZIP of every tile of a puzzle, named by a
+// template rendered for the tile's solution position. All names are checked
+// before the response starts.
+func exportTilesetHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req ExportTilesetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !validFolderName(req.Folder) {
+		http.Error(w, "Invalid folder name", http.StatusBadRequest)
+		return
+	}
+	if !requirePuzzleVisible(w, r, req.Folder) {
+		return
+	}
+	tmpl, err := parseTileNameTemplate(req.NameTemplate)
+	if err != nil {
+		http.Error(w, "Invalid nameTemplate: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	st := storeFor(r)
+	manifest, err := st.loadManifest(req.Folder)
+	if err != nil {
+		http.Error(w, "Error reading manifest.json: "+err.Error(), http.StatusNotFound)
+		return
+	}
+
+	// Name the tiles in row-major order of their solution positions
+	type namedTile struct{ file, name string }
+	rows, cols := manifest.gridSize()
+	piecesDir := filepath.Join(st.puzzlePath(req.Folder), "pieces")
+	var tiles []namedTile
+	used := make(map[string]string)
+	for row := 0; row < rows; row++ {
+		for col := 0; col < cols; col++ {
+			pos := fmt.Sprintf("%d,%d", row, col)
+			file, ok := manifest.Solution[pos]
+			if !ok {
+				continue
+			}
+			data, err := os.ReadFile(filepath.Join(piecesDir, file))
+			if err != nil {
+				http.Error(w, "Error reading tile "+file+": "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			name, err := renderTileName(tmpl, tileNameData{Index: row*cols + col, Row: row, Col: col, Hash: tileHash(data)})
+			if err != nil {
+				http.Error(w, "Invalid nameTemplate: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			if other, ok := used[name]; ok {
+				http.Error(w, fmt.Sprintf("Invalid nameTemplate: %s and %s are both named %q", other, pos, name), http.StatusBadRequest)
+				return
+			}
+			used[name] = pos
+			tiles = append(tiles, namedTile{file, name})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s_tileset.zip"`, req.Folder))
+
+	zw := zip.NewWriter(w)
+	for _, tile := range tiles {
+		if err := addZipFile(zw, filepath.Join(piecesDir, tile.file), tile.name); err != nil {
+			// The response has started, so all we can do is cut it short
+			log.Printf("exportTileset %s: %v", req.Folder, err)
+			return
+		}
+	}
+	if err := zw.Close(); err != nil {
+		log.Printf("exportTileset %s: %v", req.Folder, err)
+	}
+}
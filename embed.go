@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"slices"
+)
+
+// embedTemplate is a stripped down, self-contained puzzle viewer for use in
+// iframes. The tile names are injected server side; clicking two tiles swaps
+// them.
+var embedTemplate = template.Must(template.New("embed").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+  <title>{{.Name}}</title>
+  <style>
+    html, body { margin: 0; padding: 0; background: #222; font-family: sans-serif; }
+    #grid { display: grid; grid-template-columns: repeat({{.Cols}}, 1fr); gap: 1px; max-width: 100vw; }
+    #grid img { width: 100%; display: block; cursor: pointer; }
+    #grid img.selected { outline: 3px solid #ffcc00; outline-offset: -3px; }
+    #status { color: #eee; padding: 4px 8px; font-size: 14px; }
+  </style>
+</head>
+<body>
+  <div id="status">{{.Name}}</div>
+  <div id="grid"></div>
+  <script>
+    const folder = {{.Folder}};
+    const imagesUrl = {{.Images}};
+    const altTextUrl = {{.AltText}};
+    const checkUrl = {{.CheckSolution}};
+    const pieces = {{.Pieces}};
+    const rows = {{.Rows}}, cols = {{.Cols}};
+    const grid = document.getElementById('grid');
+    const status = document.getElementById('status');
+
+    const positions = [];
+    for (let r = 0; r < rows; r++) {
+      for (let c = 0; c < cols; c++) positions.push(r + ',' + c);
+    }
+    // The page never sees the solution: it shuffles the tiles and asks
+    // /checkSolution whether the board is solved
+    const files = positions.map((pos, i) => pieces[i]);
+    for (let i = files.length - 1; i > 0; i--) {
+      const j = Math.floor(Math.random() * (i + 1));
+      [files[i], files[j]] = [files[j], files[i]];
+    }
+
+    let selected = null;
+    const cells = positions.map((pos, i) => {
+      const img = document.createElement('img');
+      img.dataset.pos = pos;
+      img.alt = 'Puzzle piece';
+      setTile(img, files[i]);
+      img.addEventListener('click', () => select(img));
+      grid.appendChild(img);
+      return img;
+    });
+
+    function setTile(img, file) {
+      img.dataset.file = file || '';
+      img.src = file ? imagesUrl + folder + '/pieces/' + file : '';
+      img.removeAttribute('aria-label');
+      if (file) {
+        altText(file).then(text => {
+          if (text && img.dataset.file === file) img.setAttribute('aria-label', text);
+        });
+      }
+    }
+
+    // Screen reader descriptions, fetched once per tile
+    const altTexts = new Map();
+    function altText(file) {
+      if (!altTexts.has(file)) {
+        const url = altTextUrl + '?folder=' + encodeURIComponent(folder) + '&file=' + encodeURIComponent(file);
+        altTexts.set(file, fetch(url)
+          .then(res => res.ok ? res.json() : null)
+          .then(data => data && data.altText)
+          .catch(() => null));
+      }
+      return altTexts.get(file);
+    }
+
+    function select(img) {
+      if (!selected) {
+        selected = img;
+        img.classList.add('selected');
+        return;
+      }
+      const a = selected.dataset.file, b = img.dataset.file;
+      setTile(selected, b);
+      setTile(img, a);
+      selected.classList.remove('selected');
+      selected = null;
+      const placements = {};
+      for (const cell of cells) {
+        if (cell.dataset.file) placements[cell.dataset.pos] = cell.dataset.file;
+      }
+      fetch(checkUrl, {
+        method: 'POST',
+        headers: {'Content-Type': 'application/json'},
+        body: JSON.stringify({folder, placements}),
+      })
+        .then(res => res.ok ? res.json() : null)
+        .then(check => {
+          if (check && check.solved) status.textContent = {{.Name}} + ' - solved!';
+        })
+        .catch(() => {});
+    }
+  </script>
+</body>
+</html>
+`))
+
+// embedHandler serves a minimal viewer page for a puzzle that third-party
+// sites may frame.
+func embedHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	folder := r.URL.Query().Get("folder")
+	if !validFolderName(folder) {
+		http.Error(w, "Invalid folder name", http.StatusBadRequest)
+		return
+	}
+
+	st := storeFor(r)
+	manifest, err := st.loadManifest(folder)
+	if err != nil {
+		http.Error(w, "Error reading manifest.json: "+err.Error(), http.StatusNotFound)
+		return
+	}
+
+	name := folder
+	if entry, ok, err := st.findImageEntry(folder); err == nil && ok {
+		name = entry.Name
+	}
+	rows, cols := manifest.gridSize()
+	pieces := make([]string, 0, len(manifest.Pieces))
+	for _, piece := range manifest.Pieces {
+		pieces = append(pieces, piece.File)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Frame-Options", "ALLOWALL")
+	w.Header().Set("Content-Security-Policy", "frame-ancestors *")
+	err = embedTemplate.Execute(w, map[string]any{
+		"Name":          name,
+		"Folder":        folder,
+		"Images":        st.imagesURL(""),
+		"AltText":       st.prefix + "/tileAltText",
+		"CheckSolution": st.prefix + "/checkSolution",
+		"Rows":          rows,
+		"Cols":          cols,
+		"Pieces":        pieces,
+	})
+	if err != nil {
+		log.Printf("Failed to render embed page for %s: %v", folder, err)
+	}
+}
+
+// tileAltText describes a tile for screen readers, by its place in the
+// solved puzzle if withPosition is set.
+func tileAltText(name string, manifest *Manifest, file string, withPosition bool) (string, bool) {
+	index := slices.IndexFunc(manifest.Pieces, func(p PieceInfo) bool { return p.File == file })
+	if index < 0 {
+		return "", false
+	}
+	if withPosition {
+		for pos, f := range manifest.Solution {
+			if f != file {
+				continue
+			}
+			var row, col int
+			fmt.Sscanf(pos, "%d,%d", &row, &col)
+			return fmt.Sprintf("Puzzle piece at row %d, column %d of %s. Tile %d of %d.",
+				row+1, col+1, name, index+1, len(manifest.Pieces)), true
+		}
+	}
+	return fmt.Sprintf("Puzzle piece of %s. Tile %d of %d.", name, index+1, len(manifest.Pieces)), true
+}
+
+func tileAltTextHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	folder := r.URL.Query().Get("folder")
+	if !validFolderName(folder) {
+		http.Error(w, "Invalid folder name", http.StatusBadRequest)
+		return
+	}
+	file := r.URL.Query().Get("file")
+
+	st := storeFor(r)
+	manifest, err := st.loadManifest(folder)
+	if err != nil {
+		http.Error(w, "Error reading manifest.json: "+err.Error(), http.StatusNotFound)
+		return
+	}
+	name := folder
+	if entry, ok, err := st.findImageEntry(folder); err == nil && ok {
+		name = entry.Name
+	}
+
+	text, ok := tileAltText(name, manifest, file, canSeeSolution(r, folder))
+	if !ok {
+		http.Error(w, "Tile not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"file": file, "altText": text})
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// imageEntropy returns the Shannon entropy of img's grayscale histogram in
+// bits per pixel (0-8), rounded to two decimals.
+func imageEntropy(img image.Image) float64 {
+	var histogram [256]int
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			histogram[color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y]++
+		}
+	}
+
+	total := float64(b.Dx() * b.Dy())
+	var entropy float64
+	for _, n := range histogram {
+		if n > 0 {
+			p := float64(n) / total
+			entropy -= p * math.Log2(p)
+		}
+	}
+	return math.Round(entropy*100) / 100
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+type EqualizeHistogramRequest struct {
+	Folder string   `json:"folder"`
+	Files  []string `json:"files"` // empty means every tile
+}
+
+// equalizeHistogram spreads the grayscale intensities of img over the full
+// range and scales each colour channel of a pixel by the gain its intensity
+// received. It reports false for a flat image, which has nothing to spread.
+func equalizeHistogram(img image.Image) (*image.NRGBA, bool) {
+	b := img.Bounds()
+	var histogram [256]int
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			histogram[color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y]++
+		}
+	}
+
+	// Cumulative distribution, then the standard equalisation mapping
+	var cdf [256]int
+	sum := 0
+	for v, n := range histogram {
+		sum += n
+		cdf[v] = sum
+	}
+	cdfMin := 0
+	for _, c := range cdf {
+		if c > 0 {
+			cdfMin = c
+			break
+		}
+	}
+	total := b.Dx() * b.Dy()
+	if total == cdfMin {
+		return nil, false
+	}
+	var mapping [256]float64
+	for v := range mapping {
+		mapping[v] = math.Round(float64(cdf[v]-cdfMin) / float64(total-cdfMin) * 255)
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			gray := color.GrayModel.Convert(c).(color.Gray).Y
+			gain := 1.0
+			if gray > 0 {
+				gain = mapping[gray] / float64(gray)
+			}
+			scale := func(v uint8) uint8 { return uint8(min(math.Round(float64(v)*gain), 255)) }
+			dst.SetNRGBA(x-b.Min.X, y-b.Min.Y, color.NRGBA{R: scale(c.R), G: scale(c.G), B: scale(c.B), A: c.A})
+		}
+	}
+	return dst, true
+}
+
+// equalizeHistogramHandler equalises the histograms of some or all tiles of
+// a puzzle in place.
+func equalizeHistogramHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req EqualizeHistogramRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !validFolderName(req.Folder) {
+		http.Error(w, "Invalid folder name", http.StatusBadRequest)
+		return
+	}
+	if !requirePuzzleVisible(w, r, req.Folder) {
+		return
+	}
+
+	st := storeFor(r)
+	manifestMutex.Lock()
+	defer manifestMutex.Unlock()
+
+	manifest, err := st.loadManifest(req.Folder)
+	if err != nil {
+		http.Error(w, "Error reading manifest.json: "+err.Error(), http.StatusNotFound)
+		return
+	}
+
+	files := req.Files
+	if len(files) == 0 {
+		for _, piece := range manifest.Pieces {
+			files = append(files, piece.File)
+		}
+	}
+	isPiece := func(file string) bool {
+		return slices.ContainsFunc(manifest.Pieces, func(p PieceInfo) bool { return p.File == file })
+	}
+
+	// Every tile is written to a temporary file first and only renamed over
+	// the original once all of them are, so a failure leaves the puzzle as
+	// it was rather than half equalized
+	type equalizedTile struct {
+		file, tmpPath, format string
+		img                   image.Image
+		size                  int64
+		entropy               float64
+	}
+	var pending []equalizedTile
+	defer func() {
+		for _, tile := range pending {
+			os.Remove(tile.tmpPath) // fails harmlessly once renamed
+		}
+	}()
+
+	skipped := 0
+	piecesPath := filepath.Join(st.puzzlePath(req.Folder), "pieces")
+	for _, file := range files {
+		if !isPiece(file) {
+			skipped++
+			continue
+		}
+		tileFile, err := os.Open(filepath.Join(piecesPath, file))
+		if err != nil {
+			skipped++
+			continue
+		}
+		img, format, err := image.Decode(tileFile)
+		tileFile.Close()
+		if err != nil {
+			skipped++
+			continue
+		}
+
+		equalized, ok := equalizeHistogram(img)
+		if !ok {
+			skipped++
+			continue
+		}
+		var buf bytes.Buffer
+		if err := encodeTile(&buf, equalized, format); err != nil {
+			http.Error(w, "Error encoding tile: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		tmpPath, err := writeTempTile(piecesPath, file, buf.Bytes())
+		if err != nil {
+			http.Error(w, "Error writing tile file: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		tile := equalizedTile{file: file, tmpPath: tmpPath, format: format, img: equalized, size: int64(buf.Len())}
+		if manifest.PieceEntropies != nil {
+			tile.entropy = imageEntropy(equalized)
+		}
+		pending = append(pending, tile)
+	}
+
+	processed := 0
+	var writeErr error
+	for _, tile := range pending {
+		if writeErr = os.Rename(tile.tmpPath, filepath.Join(piecesPath, tile.file)); writeErr != nil {
+			break
+		}
+		manifest.setPieceSize(tile.file, tile.size)
+		if manifest.PieceEntropies != nil {
+			manifest.PieceEntropies[tile.file] = tile.entropy
+		}
+		processed++
+		if writeErr = st.writeResolutionTiles(req.Folder, manifest, tile.file, tile.img, tile.format); writeErr != nil {
+			break
+		}
+	}
+
+	// Record the tiles that did change even if a later rename failed
+	if processed > 0 {
+		if err := st.writeManifest(req.Folder, manifest); err != nil {
+			log.Printf("Failed to record tile sizes and entropies of %s: %v", req.Folder, err)
+		}
+		st.invalidateExportCache(req.Folder)
+	}
+	if writeErr != nil {
+		http.Error(w, "Error writing tile file: "+writeErr.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"processed": processed, "skipped": skipped})
+}
+
+// writeTempTile writes data to a new dot file in dir, next to the tile it
+// will replace, and returns its path.
+func writeTempTile(dir, file string, data []byte) (path string, err error) {
+	f, err := os.CreateTemp(dir, "."+file+".*.tmp")
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	if _, err := f.Write(data); err != nil {
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	// CreateTemp makes the file 0600; keep tiles readable as before
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		return "", err
+	}
+	return f.Name(), nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// readPieces returns the contents of every file in a puzzle's pieces/.
+func readPieces(t *testing.T, st *store, folder string) map[string][]byte {
+	t.Helper()
+	return readPiecesDir(t, st, folder, "pieces")
+}
+
+// readPiecesDir returns the contents of every file in one of a puzzle's
+// pieces directories.
+func readPiecesDir(t *testing.T, st *store, folder, piecesDir string) map[string][]byte {
+	t.Helper()
+	dir := filepath.Join(st.puzzlePath(folder), piecesDir)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{}
+	for _, entry := range entries {
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[entry.Name()] = data
+	}
+	return files
+}
+
+func TestEqualizeHistogram(t *testing.T) {
+	mux, st := newTestMux(t)
+	mustUpload(t, mux, encodePNG(t, testImage(128, 128, 1)), map[string]string{
+		"name": "foo", "columns": "2", "tileSize": "64",
+	})
+	before := readPieces(t, st, "foo")
+
+	rec := postJSON(t, mux, "/equalizeHistogram", EqualizeHistogramRequest{Folder: "foo"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var resp struct{ Processed, Skipped int }
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Processed != 4 || resp.Skipped != 0 {
+		t.Errorf("processed %d, skipped %d, want 4 and 0", resp.Processed, resp.Skipped)
+	}
+
+	after := readPieces(t, st, "foo")
+	if len(after) != len(before) {
+		t.Errorf("pieces/ holds %d files, want %d: temporary files left?", len(after), len(before))
+	}
+	manifest, err := st.loadManifest("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, piece := range manifest.Pieces {
+		if bytes.Equal(after[piece.File], before[piece.File]) {
+			t.Errorf("%s unchanged", piece.File)
+		}
+		if piece.SizeBytes != int64(len(after[piece.File])) {
+			t.Errorf("%s: manifest size %d, file size %d", piece.File, piece.SizeBytes, len(after[piece.File]))
+		}
+	}
+}
+
+func TestEqualizeHistogramFailureLeavesTiles(t *testing.T) {
+	mux, st := newTestMux(t)
+	mustUpload(t, mux, encodePNG(t, testImage(128, 128, 1)), map[string]string{
+		"name": "foo", "columns": "2", "tileSize": "64",
+	})
+
+	// A tile name too long for its temporary file makes the second tile
+	// fail after the first has been equalized
+	manifest, err := st.loadManifest("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(st.puzzlePath("foo"), "pieces")
+	long := strings.Repeat("x", 240) + ".png"
+	old := manifest.Pieces[1].File
+	if err := os.Rename(filepath.Join(dir, old), filepath.Join(dir, long)); err != nil {
+		t.Fatal(err)
+	}
+	manifest.Pieces[1].File = long
+	for pos, file := range manifest.Solution {
+		if file == old {
+			manifest.Solution[pos] = long
+		}
+	}
+	if err := st.writeManifest("foo", manifest); err != nil {
+		t.Fatal(err)
+	}
+	before := readPieces(t, st, "foo")
+
+	rec := postJSON(t, mux, "/equalizeHistogram", EqualizeHistogramRequest{Folder: "foo"})
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status %d, want 500: %s", rec.Code, rec.Body)
+	}
+	after := readPieces(t, st, "foo")
+	if len(after) != len(before) {
+		t.Errorf("pieces/ holds %d files, want %d: temporary files left?", len(after), len(before))
+	}
+	for file, data := range before {
+		if !bytes.Equal(after[file], data) {
+			t.Errorf("%s changed by a failed equalization", file)
+		}
+	}
+}
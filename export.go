@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/HugoSmits86/nativewebp"
+)
+
+const defaultExportQuality = 85
+
+// exportFormat is how /exportPuzzle encodes the assembled board.
+type exportFormat struct {
+	Name    string // "png", "jpeg" or "webp"
+	Quality int    // 1-100; only used for jpeg
+}
+
+var exportContentTypes = map[string]string{
+	"png":  "image/png",
+	"jpeg": "image/jpeg",
+	"webp": "image/webp",
+}
+
+// parseExportFormat reads the format and quality query parameters of an
+// export.
+func parseExportFormat(r *http.Request) (exportFormat, error) {
+	f := exportFormat{Name: r.URL.Query().Get("format"), Quality: defaultExportQuality}
+	if f.Name == "" {
+		f.Name = "png"
+	}
+	if _, ok := exportContentTypes[f.Name]; !ok {
+		return f, errors.New("Invalid format: must be png, jpeg or webp")
+	}
+	if q := r.URL.Query().Get("quality"); q != "" {
+		quality, err := strconv.Atoi(q)
+		if err != nil || quality < 1 || quality > 100 {
+			return f, errors.New("Invalid quality: must be between 1 and 100")
+		}
+		f.Quality = quality
+	}
+	if f.Name != "jpeg" {
+		f.Quality = 0 // lossless, so keep it out of the cache key
+	}
+	return f, nil
+}
+
+func (f exportFormat) encode(w io.Writer, img image.Image) error {
+	switch f.Name {
+	case "jpeg":
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: f.Quality})
+	case "webp":
+		// A pure Go, lossless encoder, so builds need no C toolchain
+		return nativewebp.Encode(w, img, nil)
+	}
+	return png.Encode(w, img)
+}
+
+// alphaImage has image/png write an alpha channel even when every pixel is
+// opaque, which it otherwise leaves out. nativewebp's lossless format
+// stores alpha with every pixel anyway.
+type alphaImage struct{ image.Image }
+
+func (alphaImage) Opaque() bool { return false }
+
+// exportImage returns the assembled canvas of an export, ready to encode.
+func (p ExportPayload) exportImage(dst *image.RGBA) image.Image {
+	if p.Transparent {
+		return alphaImage{dst}
+	}
+	return dst
+}
+
+// setHeaders sets the Content-Type and download filename of an export.
+func (f exportFormat) setHeaders(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", exportContentTypes[f.Name])
+	w.Header().Set("Content-Disposition", `attachment; filename="puzzle.`+f.Name+`"`)
+}
+
+// exportFileMaxAge is how long files written by /exportPuzzleProgress are
+// kept for download.
+const exportFileMaxAge = 10 * time.Minute
+
+// exportsPath is where /exportPuzzleProgress writes finished exports. Like
+// the trash it is a dot directory, so it is never taken for a puzzle.
+func (st *store) exportsPath() string {
+	return filepath.Join(st.root, ".exports")
+}
+
+// newExportID returns a random (version 4) UUID.
+func newExportID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// ExportProgress is an event of /exportPuzzleProgress.
+type ExportProgress struct {
+	Done   int    `json:"done,omitempty"`
+	Total  int    `json:"total,omitempty"`
+	Status string `json:"status,omitempty"` // "done" or "error" on the last event
+	URL    string `json:"url,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// exportPayloadFromQuery reads the fields of an ExportPayload from query
+// parameters of the same names, placements holding the JSON object.
+func exportPayloadFromQuery(r *http.Request) (ExportPayload, error) {
+	payload := ExportPayload{Folder: r.FormValue("folder")}
+	if err := json.Unmarshal([]byte(formValueOr(r, "placements", "{}")), &payload.Placements); err != nil {
+		return payload, errors.New("Invalid placements: " + err.Error())
+	}
+	transparent, err := strconv.ParseBool(formValueOr(r, "transparent", "false"))
+	if err != nil {
+		return payload, errors.New("Invalid transparent: must be true or false")
+	}
+	payload.Transparent = transparent
+	for _, p := range []struct {
+		name string
+		v    *int
+	}{
+		{"canvasRows", &payload.CanvasRows},
+		{"canvasCols", &payload.CanvasCols},
+		{"tileSize", &payload.TileSize},
+	} {
+		n, err := strconv.Atoi(formValueOr(r, p.name, "0"))
+		if err != nil {
+			return payload, errors.New("Invalid " + p.name + ": must be a number")
+		}
+		*p.v = n
+	}
+	return payload, nil
+}
+
+// exportPuzzleProgressHandler serves GET /exportPuzzleProgress?folder=
+// &placements=<json>, the export of /exportPuzzle as Server-Sent Events:
+// {"done":N,"total":M} after each tile and finally {"status":"done",
+// "url":"/exports/<uuid>.png"}. The transparent, canvasRows, canvasCols and
+// tileSize parameters are the fields of the /exportPuzzle body, and format
+// and quality work as for /exportPuzzle.
+func exportPuzzleProgressHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	payload, err := exportPayloadFromQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	format, err := parseExportFormat(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	st := storeFor(r)
+	manifest, ok := checkExport(w, r, payload, format)
+	if !ok {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	release, ok := st.acquireExportSlot(payload.Folder)
+	if !ok {
+		w.Header().Set("Retry-After", "5")
+		http.Error(w, "Too many exports of this puzzle in progress", http.StatusServiceUnavailable)
+		return
+	}
+	defer release()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	send := func(p ExportProgress) {
+		data, _ := json.Marshal(p)
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		flusher.Flush()
+	}
+	fail := func(msg string, err error) {
+		log.Printf("Failed to export %s: %s: %v", payload.Folder, msg, err)
+		send(ExportProgress{Status: "error", Error: msg})
+	}
+
+	img := assembleExport(st, payload, manifest, func(done, total int) {
+		send(ExportProgress{Done: done, Total: total})
+	})
+	if r.Context().Err() != nil {
+		return // the client has gone, so nobody will download it
+	}
+
+	if err := os.MkdirAll(st.exportsPath(), 0755); err != nil {
+		fail("Error creating exports directory", err)
+		return
+	}
+	name := newExportID() + "." + format.Name
+	path := filepath.Join(st.exportsPath(), name)
+	var buf bytes.Buffer
+	if err := format.encode(&buf, payload.exportImage(img)); err != nil {
+		fail("Error encoding export", err)
+		return
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		os.Remove(path)
+		fail("Error writing export", err)
+		return
+	}
+	send(ExportProgress{Status: "done", URL: st.prefix + "/exports/" + name})
+}
+
+// exportsHandler serves a file written by /exportPuzzleProgress. The
+// directory itself is not listed, so only clients that were given a name
+// can download an export.
+func exportsHandler(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/exports/")
+	if !validFolderName(name) || strings.HasPrefix(name, ".") {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeFile(w, r, filepath.Join(storeFor(r).exportsPath(), name))
+}
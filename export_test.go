@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"image"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// uploadExportPuzzle uploads a 2x2 puzzle of 64px tiles named foo.
+func uploadExportPuzzle(t *testing.T) (*http.ServeMux, *Manifest) {
+	t.Helper()
+	mux, st := newTestMux(t)
+	mustUpload(t, mux, encodePNG(t, testImage(128, 128, 1)), map[string]string{
+		"name": "foo", "columns": "2", "tileSize": "64",
+	})
+	manifest, err := st.loadManifest("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mux, manifest
+}
+
+func TestExportRejectsUnknownPieces(t *testing.T) {
+	mux, manifest := uploadExportPuzzle(t)
+	payload := ExportPayload{Folder: "foo", Placements: map[string]PlacedTile{
+		"0,0": {File: manifest.Solution["0,0"]},
+		"0,1": {File: "../../manifest.json"},
+		"1,0": {File: "missing.png"},
+		"1,1": {File: "missing.png"},
+	}}
+
+	rec := postJSON(t, mux, "/exportPuzzle", payload)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status %d, want 400: %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		Error        string
+		UnknownFiles []string
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"../../manifest.json", "missing.png"}; !reflect.DeepEqual(resp.UnknownFiles, want) {
+		t.Errorf("unknownFiles %v, want %v", resp.UnknownFiles, want)
+	}
+}
+
+func TestExportRejectsFolderTraversal(t *testing.T) {
+	mux, _ := uploadExportPuzzle(t)
+	for _, folder := range []string{"../foo", "foo/..", "/etc", ""} {
+		rec := postJSON(t, mux, "/exportPuzzle", ExportPayload{Folder: folder})
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("folder %q: status %d, want 400", folder, rec.Code)
+		}
+	}
+}
+
+func TestExportFormats(t *testing.T) {
+	mux, manifest := uploadExportPuzzle(t)
+	payload := ExportPayload{Folder: "foo", Placements: placementsFromSolution(manifest.Solution)}
+
+	tests := []struct {
+		query       string
+		status      int
+		contentType string
+		filename    string
+	}{
+		{"", http.StatusOK, "image/png", "puzzle.png"},
+		{"?format=png", http.StatusOK, "image/png", "puzzle.png"},
+		{"?format=jpeg", http.StatusOK, "image/jpeg", "puzzle.jpeg"},
+		{"?format=jpeg&quality=1", http.StatusOK, "image/jpeg", "puzzle.jpeg"},
+		{"?format=jpeg&quality=100", http.StatusOK, "image/jpeg", "puzzle.jpeg"},
+		{"?format=webp", http.StatusOK, "image/webp", "puzzle.webp"},
+		{"?format=webp&quality=50", http.StatusOK, "image/webp", "puzzle.webp"},
+		{"?format=jpeg&quality=0", http.StatusBadRequest, "", ""},
+		{"?format=jpeg&quality=101", http.StatusBadRequest, "", ""},
+		{"?format=jpeg&quality=high", http.StatusBadRequest, "", ""},
+		{"?format=gif", http.StatusBadRequest, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			rec := postJSON(t, mux, "/exportPuzzle"+tt.query, payload)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != tt.contentType {
+				t.Errorf("Content-Type %q, want %q", ct, tt.contentType)
+			}
+			if cd, want := rec.Header().Get("Content-Disposition"), `attachment; filename="`+tt.filename+`"`; cd != want {
+				t.Errorf("Content-Disposition %q, want %q", cd, want)
+			}
+			if sniffed := sniffImageType(rec.Body.Bytes()); sniffed != tt.contentType {
+				t.Errorf("body is %s", sniffed)
+			}
+			img, err := decodeUpload(bytes.NewReader(rec.Body.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if size := img.Bounds().Size(); size.X != 128 || size.Y != 128 {
+				t.Errorf("exported %v, want 128x128", size)
+			}
+		})
+	}
+}
+
+// flushRecorder records how much of the body had been written at each
+// Flush.
+type flushRecorder struct {
+	*httptest.ResponseRecorder
+	flushedAt []int
+}
+
+func (f *flushRecorder) Flush() {
+	f.flushedAt = append(f.flushedAt, f.Body.Len())
+	f.ResponseRecorder.Flush()
+}
+
+func TestExportStreams(t *testing.T) {
+	// Noise does not compress, so the PNG spans many flush intervals
+	rng := rand.New(rand.NewSource(1))
+	noise := image.NewRGBA(image.Rect(0, 0, 512, 512))
+	rng.Read(noise.Pix)
+	for i := 3; i < len(noise.Pix); i += 4 {
+		noise.Pix[i] = 255
+	}
+	mux, st := newTestMux(t)
+	mustUpload(t, mux, encodePNG(t, noise), map[string]string{"name": "foo", "columns": "2", "tileSize": "256"})
+	manifest, err := st.loadManifest("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := json.Marshal(ExportPayload{Folder: "foo", Placements: placementsFromSolution(manifest.Solution)})
+	rec := &flushRecorder{ResponseRecorder: httptest.NewRecorder()}
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/exportPuzzle", bytes.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if len(rec.flushedAt) < 2 || rec.flushedAt[0] == 0 || rec.flushedAt[0] >= rec.Body.Len() {
+		t.Errorf("flushed at %v of %d bytes, want the first flush before encoding finished", rec.flushedAt, rec.Body.Len())
+	}
+	if _, err := decodeUpload(bytes.NewReader(rec.Body.Bytes())); err != nil {
+		t.Errorf("streamed export does not decode: %v", err)
+	}
+}
+
+func TestExportTransparent(t *testing.T) {
+	mux, manifest := uploadExportPuzzle(t)
+	// Only the top left tile is placed on a 2x2 canvas
+	payload := ExportPayload{
+		Folder:      "foo",
+		Placements:  map[string]PlacedTile{"0,0": {File: manifest.Solution["0,0"]}},
+		Transparent: true,
+		CanvasRows:  2,
+		CanvasCols:  2,
+	}
+
+	for _, format := range []string{"png", "webp"} {
+		t.Run(format, func(t *testing.T) {
+			rec := postJSON(t, mux, "/exportPuzzle?format="+format, payload)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body)
+			}
+			img, err := decodeUpload(bytes.NewReader(rec.Body.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, p := range []image.Point{{100, 10}, {10, 100}, {127, 127}} {
+				if _, _, _, a := img.At(p.X, p.Y).RGBA(); a != 0 {
+					t.Errorf("empty cell pixel %v has alpha %d, want 0", p, a)
+				}
+			}
+			if _, _, _, a := img.At(10, 10).RGBA(); a != 0xffff {
+				t.Errorf("tile pixel has alpha %d, want opaque", a)
+			}
+		})
+	}
+
+	t.Run("jpeg", func(t *testing.T) {
+		if rec := postJSON(t, mux, "/exportPuzzle?format=jpeg", payload); rec.Code != http.StatusBadRequest {
+			t.Errorf("status %d, want 400", rec.Code)
+		}
+	})
+
+	// With every cell filled no pixel is transparent, yet the PNG must
+	// still have an alpha channel: colour type 6 rather than 2
+	t.Run("opaque png", func(t *testing.T) {
+		full := ExportPayload{Folder: "foo", Placements: map[string]PlacedTile{}}
+		for pos, file := range manifest.Solution {
+			full.Placements[pos] = PlacedTile{File: file}
+		}
+		for _, tc := range []struct {
+			transparent bool
+			colorType   byte
+		}{{false, 2}, {true, 6}} {
+			full.Transparent = tc.transparent
+			rec := postJSON(t, mux, "/exportPuzzle?format=png", full)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body)
+			}
+			if got := rec.Body.Bytes()[25]; got != tc.colorType {
+				t.Errorf("transparent %v: PNG colour type %d, want %d", tc.transparent, got, tc.colorType)
+			}
+		}
+	})
+}
+
+func TestExportCanvasSize(t *testing.T) {
+	mux, manifest := uploadExportPuzzle(t)
+	at := func(positions ...string) map[string]PlacedTile {
+		placements := map[string]PlacedTile{}
+		for _, pos := range positions {
+			placements[pos] = PlacedTile{File: manifest.Solution["0,0"]}
+		}
+		return placements
+	}
+
+	tests := []struct {
+		name          string
+		payload       ExportPayload
+		status        int
+		width, height int
+	}{
+		{"inferred", ExportPayload{Placements: at("1,0")}, http.StatusOK, 64, 128},
+		{"padded", ExportPayload{Placements: at("0,0"), CanvasRows: 3, CanvasCols: 4}, http.StatusOK, 256, 192},
+		{"clipped", ExportPayload{Placements: at("0,0", "1,1"), CanvasRows: 1, CanvasCols: 1}, http.StatusOK, 64, 64},
+		{"largest grid", ExportPayload{Placements: at("99,99"), TileSize: 1}, http.StatusOK, 100, 100},
+		{"canvasRows over the maximum", ExportPayload{Placements: at("0,0"), CanvasRows: maxExportCells + 1}, http.StatusBadRequest, 0, 0},
+		{"canvasCols over the maximum", ExportPayload{Placements: at("0,0"), CanvasCols: maxExportCells + 1}, http.StatusBadRequest, 0, 0},
+		{"row over the maximum", ExportPayload{Placements: at("100,0")}, http.StatusBadRequest, 0, 0},
+		{"col over the maximum", ExportPayload{Placements: at("0,100")}, http.StatusBadRequest, 0, 0},
+		{"negative position", ExportPayload{Placements: at("-1,0")}, http.StatusBadRequest, 0, 0},
+		{"malformed position", ExportPayload{Placements: at("top left")}, http.StatusBadRequest, 0, 0},
+		{"too many pixels", ExportPayload{Placements: at("0,0"), CanvasRows: 100, CanvasCols: 100, TileSize: 2048}, http.StatusBadRequest, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.payload.Folder = "foo"
+			rec := postJSON(t, mux, "/exportPuzzle", tt.payload)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			img, err := decodeUpload(bytes.NewReader(rec.Body.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if size := img.Bounds().Size(); size.X != tt.width || size.Y != tt.height {
+				t.Errorf("canvas %v, want %dx%d", size, tt.width, tt.height)
+			}
+		})
+	}
+}
+
+func TestExportProgress(t *testing.T) {
+	mux, manifest := uploadExportPuzzle(t)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	placements, err := json.Marshal(placementsFromSolution(manifest.Solution))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := url.Values{
+		"folder":      {"foo"},
+		"placements":  {string(placements)},
+		"transparent": {"true"},
+		"canvasRows":  {"3"},
+		"canvasCols":  {"3"},
+		"tileSize":    {"64"},
+		"format":      {"webp"},
+	}
+	resp, err := http.Get(srv.URL + "/exportPuzzleProgress?" + q.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %s", resp.Status)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type %q", ct)
+	}
+
+	var events []ExportProgress
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var event ExportProgress
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, event)
+	}
+	if len(events) != len(manifest.Solution)+1 {
+		t.Fatalf("got %d events, want %d: %+v", len(events), len(manifest.Solution)+1, events)
+	}
+	for i, event := range events[:len(events)-1] {
+		if event.Done != i+1 || event.Total != len(manifest.Solution) {
+			t.Errorf("event %d: %+v, want done %d of %d", i, event, i+1, len(manifest.Solution))
+		}
+	}
+	last := events[len(events)-1]
+	if last.Status != "done" || !strings.HasSuffix(last.URL, ".webp") {
+		t.Fatalf("last event %+v", last)
+	}
+
+	download := get(mux, last.URL)
+	if download.Code != http.StatusOK {
+		t.Fatalf("download: %d %s", download.Code, download.Body)
+	}
+	img, err := decodeUpload(bytes.NewReader(download.Body.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := img.Bounds().Size(); size.X != 192 || size.Y != 192 {
+		t.Errorf("canvas %v, want 192x192", size)
+	}
+	if _, _, _, a := img.At(160, 160).RGBA(); a != 0 {
+		t.Errorf("empty cell has alpha %d, want 0", a)
+	}
+
+	for name, query := range map[string]string{
+		"transparent jpeg":    "folder=foo&transparent=true&format=jpeg",
+		"invalid transparent": "folder=foo&transparent=maybe",
+		"invalid canvasRows":  "folder=foo&canvasRows=two",
+		"too many canvasCols": "folder=foo&canvasCols=101",
+		"invalid tileSize":    "folder=foo&tileSize=big",
+	} {
+		if rec := get(mux, "/exportPuzzleProgress?"+query); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", name, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// exportCacheTTL is how long an assembled export is kept in memory.
+const exportCacheTTL = 5 * time.Minute
+
+type exportCacheEntry struct {
+	root    string // of the store holding the puzzle
+	folder  string
+	data    []byte
+	expires time.Time
+}
+
+var (
+	exportCacheMutex sync.Mutex
+	exportCache      = make(map[string]exportCacheEntry)
+)
+
+// exportCacheKey hashes the whole export request and the store it is made
+// from. encoding/json writes map keys in sorted order, so equal placement
+// maps always produce the same key.
+func (st *store) exportCacheKey(payload ExportPayload, format exportFormat) string {
+	data, _ := json.Marshal(struct {
+		Root string
+		ExportPayload
+		Format exportFormat
+	}{st.root, payload, format})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func getCachedExport(key string) ([]byte, bool) {
+	exportCacheMutex.Lock()
+	defer exportCacheMutex.Unlock()
+
+	entry, ok := exportCache[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(entry.expires) {
+		delete(exportCache, key)
+		return nil, false
+	}
+	return entry.data, true
+}
+
+func (st *store) putCachedExport(key, folder string, data []byte) {
+	exportCacheMutex.Lock()
+	defer exportCacheMutex.Unlock()
+
+	// Drop expired entries so the cache does not grow without bound
+	now := time.Now()
+	for k, entry := range exportCache {
+		if now.After(entry.expires) {
+			delete(exportCache, k)
+		}
+	}
+	exportCache[key] = exportCacheEntry{root: st.root, folder: folder, data: data, expires: now.Add(exportCacheTTL)}
+}
+
+// invalidateExportCache removes every cached export of a puzzle of the
+// store. It must be called whenever the puzzle's tiles change or the puzzle
+// is deleted.
+func (st *store) invalidateExportCache(folder string) {
+	exportCacheMutex.Lock()
+	defer exportCacheMutex.Unlock()
+
+	for k, entry := range exportCache {
+		if entry.root == st.root && entry.folder == folder {
+			delete(exportCache, k)
+		}
+	}
+}
+
+// exportSlot identifies a puzzle; folders of different stores may share a
+// name.
+type exportSlot struct {
+	root, folder string
+}
+
+// exportSlots maps an exportSlot to a semaphore channel limiting how many
+// exports of the puzzle are assembled at once.
+var exportSlots sync.Map
+
+// acquireExportSlot takes one of the folder's export slots without
+// blocking. It reports false when all -maxExportsPerPuzzle slots are busy.
+func (st *store) acquireExportSlot(folder string) (release func(), ok bool) {
+	v, _ := exportSlots.LoadOrStore(exportSlot{st.root, folder}, make(chan struct{}, *maxExportsPerPuzzle))
+	sem := v.(chan struct{})
+	select {
+	case sem <- struct{}{}:
+		return func() { <-sem }, true
+	default:
+		return nil, false
+	}
+}
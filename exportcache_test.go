@@ -0,0 +1,44 @@
+package main
+
+import "testing"
+
+func TestExportCacheSeparatesStores(t *testing.T) {
+	a, b := &store{root: t.TempDir()}, &store{root: t.TempDir()}
+	payload := ExportPayload{Folder: "foo", Placements: map[string]PlacedTile{"0,0": {File: "tile_0_0.png"}}}
+	format := exportFormat{Name: "png"}
+
+	keyA, keyB := a.exportCacheKey(payload, format), b.exportCacheKey(payload, format)
+	if keyA == keyB {
+		t.Fatal("the same export of two stores has one cache key")
+	}
+	a.putCachedExport(keyA, "foo", []byte("a"))
+	b.putCachedExport(keyB, "foo", []byte("b"))
+
+	a.invalidateExportCache("foo")
+	if _, ok := getCachedExport(keyA); ok {
+		t.Error("export still cached after invalidating its store")
+	}
+	if data, ok := getCachedExport(keyB); !ok || string(data) != "b" {
+		t.Error("invalidating one store dropped another store's export")
+	}
+	b.invalidateExportCache("foo")
+}
+
+func TestExportSlotsSeparateStores(t *testing.T) {
+	setFlag(t, maxExportsPerPuzzle, 1)
+	a, b := &store{root: t.TempDir()}, &store{root: t.TempDir()}
+
+	releaseA, ok := a.acquireExportSlot("foo")
+	if !ok {
+		t.Fatal("no free slot")
+	}
+	defer releaseA()
+	if _, ok := a.acquireExportSlot("foo"); ok {
+		t.Error("acquired more than -maxExportsPerPuzzle slots")
+	}
+	releaseB, ok := b.acquireExportSlot("foo")
+	if !ok {
+		t.Fatal("another store's export took this store's slot")
+	}
+	releaseB()
+}
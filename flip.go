@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"image"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+type FlipPuzzleRequest struct {
+	Folder    string `json:"folder"`
+	Direction string `json:"direction"` // "horizontal" or "vertical"
+}
+
+// flipImage mirrors img left to right, or top to bottom when vertical.
+func flipImage(img image.Image, vertical bool) *image.RGBA {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := img.At(b.Min.X+x, b.Min.Y+y)
+			if vertical {
+				dst.Set(x, h-1-y, c)
+			} else {
+				dst.Set(w-1-x, y, c)
+			}
+		}
+	}
+	return dst
+}
+
+// flipPuzzleHandler mirrors a puzzle's index image and slices it again with
+// the settings recorded in its manifest, replacing tiles and solution.
+func flipPuzzleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req FlipPuzzleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !validFolderName(req.Folder) {
+		http.Error(w, "Invalid folder name", http.StatusBadRequest)
+		return
+	}
+	if !requirePuzzleVisible(w, r, req.Folder) {
+		return
+	}
+	if req.Direction != "horizontal" && req.Direction != "vertical" {
+		http.Error(w, "direction must be horizontal or vertical", http.StatusBadRequest)
+		return
+	}
+
+	st := storeFor(r)
+	manifestMutex.Lock()
+	defer manifestMutex.Unlock()
+
+	manifest, err := st.loadManifest(req.Folder)
+	if err != nil {
+		http.Error(w, "Error reading manifest.json: "+err.Error(), http.StatusNotFound)
+		return
+	}
+	opts, err := optionsFromManifest(manifest)
+	if err != nil {
+		http.Error(w, "Invalid tileNameTemplate in manifest.json: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if req.Direction == "horizontal" {
+		// Mirrored columns keep their widths
+		opts.ColumnWidths = slices.Clone(opts.ColumnWidths)
+		slices.Reverse(opts.ColumnWidths)
+	}
+	img, err := st.loadIndexImage(req.Folder, manifest)
+	if err != nil {
+		http.Error(w, "Error reading index image: "+err.Error(), http.StatusNotFound)
+		return
+	}
+
+	// Tile names may depend on content, so the old tiles cannot be reused.
+	// The puzzle is sliced into a dot directory of the store, never taken
+	// for a puzzle, and swapped in only once slicing has succeeded
+	stagingRoot, err := os.MkdirTemp(st.root, ".flip-*")
+	if err != nil {
+		http.Error(w, "Error creating staging directory: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer os.RemoveAll(stagingRoot)
+	staging := &store{root: stagingRoot, prefix: st.prefix}
+	manifest, err = slicePuzzle(staging, req.Folder, flipImage(img, req.Direction == "vertical"), opts)
+	if err != nil {
+		writeStatusError(w, err)
+		return
+	}
+	if err := swapInFiles(staging.puzzlePath(req.Folder), st.puzzlePath(req.Folder), filepath.Join(stagingRoot, ".old")); err != nil {
+		http.Error(w, "Error replacing tiles: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	st.invalidateExportCache(req.Folder)
+
+	topLeft := manifest.Solution["0,0"]
+	if _, err := st.updateImageEntry(req.Folder, func(entry *ImageEntry) { entry.Tl = topLeft }); err != nil {
+		log.Printf("Failed to update top-left tile of %s: %v", req.Folder, err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"status":   "ok",
+		"folder":   req.Folder,
+		"solution": manifest.Solution,
+		"_links":   buildLinks(req.Folder, r),
+	})
+}
+
+// swapInFiles moves every file and directory of src into dst, replacing
+// those of the same name, which are moved to backup. manifest.json goes
+// last, so it never describes tiles that are not in place yet. If a move
+// fails, the ones already made are undone.
+func swapInFiles(src, dst, backup string) error {
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Name() != "manifest.json" {
+			names = append(names, entry.Name())
+		}
+	}
+	if len(names) < len(entries) {
+		names = append(names, "manifest.json")
+	}
+	if err := os.MkdirAll(backup, 0755); err != nil {
+		return err
+	}
+
+	var swapped, replaced []string
+	undo := func() {
+		for i := len(swapped) - 1; i >= 0; i-- {
+			os.Rename(filepath.Join(dst, swapped[i]), filepath.Join(src, swapped[i]))
+		}
+		for _, name := range replaced {
+			os.Rename(filepath.Join(backup, name), filepath.Join(dst, name))
+		}
+	}
+	for _, name := range names {
+		if _, err := os.Lstat(filepath.Join(dst, name)); err == nil {
+			if err := os.Rename(filepath.Join(dst, name), filepath.Join(backup, name)); err != nil {
+				undo()
+				return err
+			}
+			replaced = append(replaced, name)
+		}
+		if err := os.Rename(filepath.Join(src, name), filepath.Join(dst, name)); err != nil {
+			undo()
+			return err
+		}
+		swapped = append(swapped, name)
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func decodePiece(t *testing.T, st *store, folder, file string) image.Image {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(st.puzzlePath(folder), "pieces", file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := decodeUpload(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+// noStagingLeft fails the test if a flip left its staging directory behind.
+func noStagingLeft(t *testing.T, st *store) {
+	t.Helper()
+	leftover, err := filepath.Glob(filepath.Join(st.root, ".flip-*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leftover) > 0 {
+		t.Errorf("staging directories left: %v", leftover)
+	}
+}
+
+func TestFlipPuzzle(t *testing.T) {
+	mux, st := newTestMux(t)
+	mustUpload(t, mux, encodePNG(t, testImage(128, 128, 1)), map[string]string{
+		"name": "foo", "columns": "2", "tileSize": "64",
+	})
+	before, err := st.loadManifest("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	topRight := decodePiece(t, st, "foo", before.Solution["0,1"])
+
+	rec := postJSON(t, mux, "/flipPuzzle", FlipPuzzleRequest{Folder: "foo", Direction: "horizontal"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	noStagingLeft(t, st)
+
+	after, err := st.loadManifest("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after.Pieces) != len(before.Pieces) {
+		t.Errorf("%d pieces after the flip, want %d", len(after.Pieces), len(before.Pieces))
+	}
+	// The old top right tile, mirrored, is the new top left tile, give or
+	// take the recompression of index.jpg it is sliced from
+	topLeft := decodePiece(t, st, "foo", after.Solution["0,0"])
+	b := topLeft.Bounds()
+	near := func(a, b uint32) bool { return max(a, b)-min(a, b) <= 8<<8 }
+	for _, p := range []image.Point{{0, 0}, {10, 50}, {63, 63}} {
+		r1, g1, b1, _ := topLeft.At(p.X, p.Y).RGBA()
+		r2, g2, b2, _ := topRight.At(b.Dx()-1-p.X, p.Y).RGBA()
+		if !near(r1, r2) || !near(g1, g2) || !near(b1, b2) {
+			t.Errorf("pixel %v: %v, want about %v", p, topLeft.At(p.X, p.Y), topRight.At(b.Dx()-1-p.X, p.Y))
+		}
+	}
+}
+
+func TestFlipPuzzleFailureLeavesPuzzle(t *testing.T) {
+	mux, st := newTestMux(t)
+	mustUpload(t, mux, encodePNG(t, testImage(128, 128, 1)), map[string]string{
+		"name": "foo", "columns": "2", "tileSize": "64",
+	})
+	// A template giving every tile the same name fails the slicing
+	manifest, err := st.loadManifest("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest.TileNameTemplate = "tile.png"
+	if err := st.writeManifest("foo", manifest); err != nil {
+		t.Fatal(err)
+	}
+	before := readPieces(t, st, "foo")
+
+	rec := postJSON(t, mux, "/flipPuzzle", FlipPuzzleRequest{Folder: "foo", Direction: "vertical"})
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "more than one tile") {
+		t.Fatalf("status %d, want 400 for duplicate names: %s", rec.Code, rec.Body)
+	}
+	noStagingLeft(t, st)
+
+	if after := readPieces(t, st, "foo"); !reflect.DeepEqual(after, before) {
+		t.Error("tiles changed by a failed flip")
+	}
+	after, err := st.loadManifest("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(after, manifest) {
+		t.Error("manifest changed by a failed flip")
+	}
+}
+
+func TestFlipDoesNotDenoiseAgain(t *testing.T) {
+	mux, st := newTestMux(t)
+	mustUpload(t, mux, encodePNG(t, testImage(128, 128, 1)), map[string]string{
+		"name": "foo", "columns": "2", "tileSize": "64", "denoiseRadius": "2", "indexFormat": "png",
+	})
+	readIndex := func() image.Image {
+		t.Helper()
+		data, err := os.ReadFile(filepath.Join(st.puzzlePath("foo"), "index.png"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := decodeUpload(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return img
+	}
+	before := readIndex()
+
+	// Two flips give back the original picture, unless each blurs it again
+	for i := 0; i < 2; i++ {
+		if rec := postJSON(t, mux, "/flipPuzzle", FlipPuzzleRequest{Folder: "foo", Direction: "horizontal"}); rec.Code != http.StatusOK {
+			t.Fatalf("flip: %d %s", rec.Code, rec.Body)
+		}
+	}
+	after := readIndex()
+
+	var diff, n uint64
+	b := before.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r1, g1, b1, _ := before.At(x, y).RGBA()
+			r2, g2, b2, _ := after.At(x, y).RGBA()
+			for _, d := range [][2]uint32{{r1, r2}, {g1, g2}, {b1, b2}} {
+				diff += uint64(max(d[0], d[1])-min(d[0], d[1])) >> 8
+				n++
+			}
+		}
+	}
+	if mean := float64(diff) / float64(n); mean > 1 {
+		t.Errorf("index image changed by %.2f per channel on average after two flips", mean)
+	}
+
+	manifest, err := st.loadManifest("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.DenoiseRadius != 2 {
+		t.Errorf("denoiseRadius %d after the flips, want 2", manifest.DenoiseRadius)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newTestMux points the default store at a temporary directory and returns
+// a mux serving the API routes from it.
+func newTestMux(t testing.TB) (*http.ServeMux, *store) {
+	t.Helper()
+	setFlag(t, &defaultStore.root, t.TempDir())
+	mux := http.NewServeMux()
+	registerRoutes(mux)
+	return mux, defaultStore
+}
+
+// setFlag sets a flag (or any package variable) for the duration of a test.
+func setFlag[T any](t testing.TB, p *T, v T) {
+	t.Helper()
+	old := *p
+	*p = v
+	t.Cleanup(func() { *p = old })
+}
+
+// testImage returns a w x h image whose pixels all differ enough for the
+// tiles cut from it to be told apart. Each seed gives a pattern of bright
+// and dark blocks of its own, so images of different seeds are not taken
+// for duplicates.
+func testImage(w, h, seed int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			block := uint32((x*9/w)*8+y*8/h) * 2654435761 * uint32(seed+1)
+			img.Set(x, y, color.RGBA{
+				R: uint8(block >> 24),
+				G: uint8(y * 255 / h),
+				B: uint8(x * 255 / w),
+				A: 255,
+			})
+		}
+	}
+	return img
+}
+
+func encodePNG(t testing.TB, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// uploadTestPuzzle posts an image to /uploadPuzzle with the given form
+// fields.
+func uploadTestPuzzle(t testing.TB, h http.Handler, image []byte, fields map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for k, v := range fields {
+		mw.WriteField(k, v)
+	}
+	fw, err := mw.CreateFormFile("image", "upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write(image)
+	mw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/uploadPuzzle", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// mustUpload uploads a puzzle and fails the test unless it was created.
+func mustUpload(t testing.TB, h http.Handler, image []byte, fields map[string]string) {
+	t.Helper()
+	if rec := uploadTestPuzzle(t, h, image, fields); rec.Code != http.StatusOK {
+		t.Fatalf("upload: %d %s", rec.Code, rec.Body)
+	}
+}
+
+// postJSON sends v as the JSON body of a request to h.
+func postJSON(t testing.TB, h http.Handler, target string, v any) *httptest.ResponseRecorder {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, target, bytes.NewReader(data)))
+	return rec
+}
+
+func get(h http.Handler, target string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	return rec
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Hint tells the player where one tile belongs.
+type Hint struct {
+	File     string `json:"file"`
+	Position string `json:"position"` // "row,col"
+}
+
+// chooseHint picks a tile that is not yet in its solved position. Tiles
+// with high entropy are hard to place by eye, so they are revealed first;
+// without per-tile entropies the first misplaced tile in reading order is.
+func chooseHint(manifest *Manifest, placements map[string]string) (Hint, bool) {
+	type candidate struct {
+		hint     Hint
+		row, col int
+		entropy  float64
+	}
+	var candidates []candidate
+	for pos, file := range manifest.Solution {
+		if placements[pos] == file {
+			continue
+		}
+		c := candidate{hint: Hint{File: file, Position: pos}, entropy: manifest.PieceEntropies[file]}
+		fmt.Sscanf(pos, "%d,%d", &c.row, &c.col)
+		candidates = append(candidates, c)
+	}
+	if len(candidates) == 0 {
+		return Hint{}, false
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.entropy != b.entropy {
+			return a.entropy > b.entropy
+		}
+		if a.row != b.row {
+			return a.row < b.row
+		}
+		return a.col < b.col
+	})
+	return candidates[0].hint, true
+}
+
+type HintRequest struct {
+	Folder    string `json:"folder"`
+	SessionID string `json:"sessionId"`
+}
+
+// hintHandler reveals where one misplaced tile of the session's saved
+// board belongs. Each session may only ask once every -hintCooldownSec.
+func hintHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req HintRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !validFolderName(req.Folder) || !validSessionID(req.SessionID) {
+		http.Error(w, "Invalid folder or sessionId", http.StatusBadRequest)
+		return
+	}
+	if !requirePuzzleVisible(w, r, req.Folder) {
+		return
+	}
+
+	st := storeFor(r)
+	manifest, err := st.loadManifest(req.Folder)
+	if err != nil {
+		http.Error(w, "Error reading manifest.json: "+err.Error(), http.StatusNotFound)
+		return
+	}
+
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	state, err := st.loadState(req.Folder, req.SessionID)
+	if err != nil {
+		http.Error(w, "Error reading state: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now().UTC()
+	if state.LastHintAt != nil {
+		if wait := state.LastHintAt.Add(time.Duration(*hintCooldownSec) * time.Second).Sub(now); wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "Hint cooldown in progress", http.StatusTooManyRequests)
+			return
+		}
+	}
+
+	hint, ok := chooseHint(manifest, state.Placements)
+	if !ok {
+		http.Error(w, "The puzzle is already solved", http.StatusConflict)
+		return
+	}
+	state.LastHintAt = &now
+	if state.SavedAt.IsZero() {
+		state.SavedAt = now
+	}
+	if err := st.writeState(state); err != nil {
+		http.Error(w, "Error writing state: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(hint)
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type ImageEntry struct {
+	Name       string     `json:"name"`
+	Folder     string     `json:"folder"`
+	Rows       int        `json:"rows"`
+	Cols       int        `json:"cols"`
+	Tl         string     `json:"tl"`
+	Index      string     `json:"index"`
+	UploaderIP string     `json:"uploaderIP,omitempty"` // hashIP of the uploader's IP; admins only
+	ThumbPath  string     `json:"thumbPath,omitempty"`  // relative to the images directory
+	Thumb      string     `json:"thumb,omitempty"`      // relative to the puzzle folder
+	PHash      string     `json:"phash,omitempty"`      // hex dHash of the uploaded image
+	Private    bool       `json:"private,omitempty"`    // only for admins and share token holders
+	Deleted    bool       `json:"deleted,omitempty"`    // soft-deleted: the folder is in .trash
+	DeletedAt  *time.Time `json:"deletedAt,omitempty"`
+}
+
+// ImageIndex is the content of a store's imageIndex.json.
+type ImageIndex struct {
+	Images []ImageEntry `json:"images"`
+}
+
+func (st *store) imageIndexPath() string {
+	return filepath.Join(st.root, "imageIndex.json")
+}
+
+// loadImageIndex reads imageIndex.json. A missing file is an empty index.
+// Callers that modify the index must hold imageIndexMutex.
+func (st *store) loadImageIndex() (ImageIndex, error) {
+	var imageIndex ImageIndex
+	data, err := os.ReadFile(st.imageIndexPath())
+	if err != nil && !os.IsNotExist(err) {
+		return imageIndex, err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &imageIndex); err != nil {
+			return imageIndex, err
+		}
+	}
+	return imageIndex, nil
+}
+
+// saveImageIndex writes imageIndex.json. Callers must hold imageIndexMutex.
+func (st *store) saveImageIndex(imageIndex ImageIndex) error {
+	return atomicWriteJSON(st.imageIndexPath(), imageIndex)
+}
+
+// atomicWriteJSON writes v as indented JSON to a temporary file next to
+// path and renames it over path, so a crash mid-write leaves either the old
+// file or the new one, never a truncated one. On failure the temporary file
+// is removed and path is untouched.
+func atomicWriteJSON(path string, v any) (err error) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	if _, err := f.Write(data); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	// CreateTemp makes the file 0600; keep the index readable as before
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// findImageEntry returns the imageIndex.json entry for a folder.
+func (st *store) findImageEntry(folder string) (ImageEntry, bool, error) {
+	imageIndexMutex.Lock()
+	imageIndex, err := st.loadImageIndex()
+	imageIndexMutex.Unlock()
+	if err != nil {
+		return ImageEntry{}, false, err
+	}
+	if i := findImageEntryIndex(imageIndex, folder); i >= 0 {
+		return imageIndex.Images[i], true, nil
+	}
+	return ImageEntry{}, false, nil
+}
+
+// findImageEntryIndex returns the position in imageIndex of the entry for
+// folder, or -1. A soft-deleted puzzle keeps its entry while a new puzzle
+// takes the name, so the live entry wins over a deleted one.
+func findImageEntryIndex(imageIndex ImageIndex, folder string) int {
+	if i := findEntryIndex(imageIndex, folder, false); i >= 0 {
+		return i
+	}
+	return findEntryIndex(imageIndex, folder, true)
+}
+
+// updateImageEntry applies update to the imageIndex.json entry of a folder
+// and saves the index. It reports whether the entry was found.
+func (st *store) updateImageEntry(folder string, update func(entry *ImageEntry)) (bool, error) {
+	imageIndexMutex.Lock()
+	defer imageIndexMutex.Unlock()
+
+	imageIndex, err := st.loadImageIndex()
+	if err != nil {
+		return false, err
+	}
+	if i := findImageEntryIndex(imageIndex, folder); i >= 0 {
+		update(&imageIndex.Images[i])
+		return true, st.saveImageIndex(imageIndex)
+	}
+	return false, nil
+}
+
+// PuzzleListItem is an imageIndex.json entry as returned by the API.
+type PuzzleListItem struct {
+	ImageEntry
+	MatchedField string            `json:"matchedField,omitempty"` // set when searching with ?q=
+	Links        map[string]string `json:"_links"`
+}
+
+// matchPuzzle returns which of the folder name, display name or description
+// of a puzzle contains the lower-cased query, or "" if none does.
+func (st *store) matchPuzzle(entry ImageEntry, query string) string {
+	if strings.Contains(strings.ToLower(entry.Folder), query) {
+		return "name"
+	}
+	if strings.Contains(strings.ToLower(entry.Name), query) {
+		return "displayName"
+	}
+	if manifest, err := st.loadManifest(entry.Folder); err == nil && strings.Contains(strings.ToLower(manifest.Description), query) {
+		return "description"
+	}
+	return ""
+}
+
+// puzzlesHandler lists the puzzles in imageIndex.json, optionally filtered
+// by a case-insensitive ?q= search. Soft-deleted puzzles are left out.
+func puzzlesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	st := storeFor(r)
+	imageIndexMutex.Lock()
+	imageIndex, err := st.loadImageIndex()
+	imageIndexMutex.Unlock()
+	if err != nil {
+		http.Error(w, "Error reading imageIndex.json: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	query := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("q")))
+	items := []PuzzleListItem{}
+	for _, entry := range imageIndex.Images {
+		if entry.Deleted || (entry.Private && !isAdmin(r)) {
+			continue
+		}
+		if !isAdmin(r) {
+			entry.UploaderIP = ""
+		}
+		item := PuzzleListItem{ImageEntry: entry, Links: buildLinks(entry.Folder, r)}
+		if query != "" {
+			if item.MatchedField = st.matchPuzzle(entry, query); item.MatchedField == "" {
+				continue
+			}
+		}
+		items = append(items, item)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(items)
+}
+
+// uploaderReportHandler serves GET /uploaderReport?ip=<address>, or
+// ?hash=<hashIP of it>, listing the puzzles uploaded from that address. The
+// hash is keyed, so operators with an address from an abuse report give it
+// as ?ip= and have it hashed here.
+func uploaderReportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	hash, ip := r.URL.Query().Get("hash"), r.URL.Query().Get("ip")
+	switch {
+	case hash != "" && ip != "":
+		http.Error(w, "Give either hash or ip, not both", http.StatusBadRequest)
+		return
+	case ip != "":
+		addr := net.ParseIP(ip)
+		if addr == nil {
+			http.Error(w, "Invalid ip address", http.StatusBadRequest)
+			return
+		}
+		hash = hashIP(addr.String())
+	case hash == "":
+		http.Error(w, "hash or ip is required", http.StatusBadRequest)
+		return
+	}
+
+	st := storeFor(r)
+	imageIndexMutex.Lock()
+	imageIndex, err := st.loadImageIndex()
+	imageIndexMutex.Unlock()
+	if err != nil {
+		http.Error(w, "Error reading imageIndex.json: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	matches := []PuzzleListItem{}
+	for _, entry := range imageIndex.Images {
+		if entry.UploaderIP == hash {
+			matches = append(matches, PuzzleListItem{ImageEntry: entry, Links: buildLinks(entry.Folder, r)})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(matches)
+}
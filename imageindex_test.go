@@ -0,0 +1,173 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAtomicWriteJSON(t *testing.T) {
+	// tmpFiles lists what a failed write might leave next to the target
+	tmpFiles := func(t *testing.T, dir string) []string {
+		t.Helper()
+		matches, err := filepath.Glob(filepath.Join(dir, "*.tmp"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return matches
+	}
+
+	t.Run("success", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "imageIndex.json")
+		want := ImageIndex{Images: []ImageEntry{{Name: "Foo", Folder: "foo"}}}
+		if err := atomicWriteJSON(path, want); err != nil {
+			t.Fatal(err)
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mode := info.Mode().Perm(); mode != 0644 {
+			t.Errorf("mode %v, want 0644", mode)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got ImageIndex
+		if err := json.Unmarshal(data, &got); err != nil || len(got.Images) != 1 || got.Images[0].Folder != "foo" {
+			t.Errorf("wrote %s", data)
+		}
+		if tmp := tmpFiles(t, dir); len(tmp) != 0 {
+			t.Errorf("left behind %v", tmp)
+		}
+	})
+
+	t.Run("encoding fails", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "imageIndex.json")
+		if err := os.WriteFile(path, []byte("original"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := atomicWriteJSON(path, math.NaN()); err == nil {
+			t.Fatal("no error writing NaN")
+		}
+		if data, _ := os.ReadFile(path); string(data) != "original" {
+			t.Errorf("original replaced by %q", data)
+		}
+		if tmp := tmpFiles(t, dir); len(tmp) != 0 {
+			t.Errorf("left behind %v", tmp)
+		}
+	})
+
+	t.Run("rename fails", func(t *testing.T) {
+		// A non-empty directory cannot be replaced by a file
+		dir := t.TempDir()
+		path := filepath.Join(dir, "imageIndex.json")
+		if err := os.Mkdir(path, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(path, "original"), []byte("original"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := atomicWriteJSON(path, ImageIndex{}); err == nil {
+			t.Fatal("no error replacing a directory")
+		}
+		if data, _ := os.ReadFile(filepath.Join(path, "original")); string(data) != "original" {
+			t.Errorf("original replaced by %q", data)
+		}
+		if tmp := tmpFiles(t, dir); len(tmp) != 0 {
+			t.Errorf("left behind %v", tmp)
+		}
+	})
+}
+
+func TestPuzzlesHidesUploaderIP(t *testing.T) {
+	mux, _ := newTestMux(t)
+	setFlag(t, adminToken, "secret")
+	mustUpload(t, mux, encodePNG(t, testImage(128, 128, 1)), map[string]string{"name": "foo", "columns": "2", "tileSize": "64"})
+
+	list := func(admin bool) []PuzzleListItem {
+		req := httptest.NewRequest(http.MethodGet, "/puzzles", nil)
+		if admin {
+			req.Header.Set("X-Admin-Token", "secret")
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		var items []PuzzleListItem
+		if err := json.Unmarshal(rec.Body.Bytes(), &items); err != nil || len(items) != 1 {
+			t.Fatalf("/puzzles: %d %s", rec.Code, rec.Body)
+		}
+		return items
+	}
+	if ip := list(false)[0].UploaderIP; ip != "" {
+		t.Errorf("public list has uploaderIP %q", ip)
+	}
+	if ip := list(true)[0].UploaderIP; ip != hashIP("192.0.2.1") {
+		t.Errorf("admin list has uploaderIP %q, want hashIP of the uploader", ip)
+	}
+}
+
+func TestHashIPIsKeyed(t *testing.T) {
+	ip := "192.0.2.1"
+	plain := sha256.Sum256([]byte(ip))
+	if hashIP(ip) == hex.EncodeToString(plain[:]) {
+		t.Error("hashIP is a plain SHA-256")
+	}
+	mac := hmac.New(sha256.New, ipHashKey())
+	mac.Write([]byte(ip))
+	if got, want := hashIP(ip), hex.EncodeToString(mac.Sum(nil)); got != want {
+		t.Errorf("hashIP %s, want the HMAC %s", got, want)
+	}
+	if hashIP(ip) == hashIP("192.0.2.2") {
+		t.Error("two addresses hash alike")
+	}
+}
+
+func TestUploaderReport(t *testing.T) {
+	mux, _ := newTestMux(t)
+	setFlag(t, adminToken, "secret")
+	mustUpload(t, mux, encodePNG(t, testImage(128, 128, 1)), map[string]string{"name": "foo", "columns": "2", "tileSize": "64"})
+
+	report := func(query string) (int, []PuzzleListItem) {
+		req := httptest.NewRequest(http.MethodGet, "/uploaderReport?"+query, nil)
+		req.Header.Set("X-Admin-Token", "secret")
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		var items []PuzzleListItem
+		if rec.Code == http.StatusOK {
+			if err := json.Unmarshal(rec.Body.Bytes(), &items); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rec.Code, items
+	}
+
+	tests := []struct {
+		query  string
+		status int
+		count  int
+	}{
+		{"ip=192.0.2.1", http.StatusOK, 1},
+		{"hash=" + hashIP("192.0.2.1"), http.StatusOK, 1},
+		{"ip=192.0.2.2", http.StatusOK, 0},
+		{"ip=not-an-ip", http.StatusBadRequest, 0},
+		{"ip=192.0.2.1&hash=" + hashIP("192.0.2.1"), http.StatusBadRequest, 0},
+		{"", http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		status, items := report(tt.query)
+		if status != tt.status || len(items) != tt.count {
+			t.Errorf("%q: status %d with %d puzzles, want %d with %d", tt.query, status, len(items), tt.status, tt.count)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"time"
+)
+
+// version and buildDate are set at build time, e.g.
+//
+//	go build -ldflags "-X main.version=1.2.0 -X main.buildDate=2024-05-01"
+var (
+	version   = "dev"
+	buildDate = "unknown"
+)
+
+var startTime = time.Now()
+
+type ServerInfo struct {
+	Version     string  `json:"version"`
+	BuildDate   string  `json:"buildDate"`
+	GoVersion   string  `json:"goVersion"`
+	OS          string  `json:"os"`
+	Arch        string  `json:"arch"`
+	Uptime      string  `json:"uptime"`
+	Goroutines  int     `json:"goroutines"`
+	MemAllocMB  float64 `json:"memAllocMB"`
+	PuzzleCount int     `json:"puzzleCount"`
+	ImagesDir   string  `json:"imagesDir"`
+}
+
+func infoHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	var puzzleCount int
+	imageIndexMutex.Lock()
+	for _, st := range allStores() {
+		if imageIndex, err := st.loadImageIndex(); err == nil {
+			puzzleCount += len(imageIndex.Images)
+		}
+	}
+	imageIndexMutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ServerInfo{
+		Version:     version,
+		BuildDate:   buildDate,
+		GoVersion:   runtime.Version(),
+		OS:          runtime.GOOS,
+		Arch:        runtime.GOARCH,
+		Uptime:      time.Since(startTime).Round(time.Second).String(),
+		Goroutines:  runtime.NumGoroutine(),
+		MemAllocMB:  float64(mem.Alloc) / (1 << 20),
+		PuzzleCount: puzzleCount,
+		ImagesDir:   defaultStore.root,
+	})
+}
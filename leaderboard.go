@@ -0,0 +1,172 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	leaderboardSize = 10
+	// leaderboardWriteTimeout bounds a write to a /leaderboardSocket
+	// client, so a stalled one is dropped instead of holding its boards
+	leaderboardWriteTimeout = 10 * time.Second
+)
+
+// leaderboardClient is a /leaderboardSocket connection. Only its writePump
+// writes to conn; boards are queued on send, which holds at most one.
+type leaderboardClient struct {
+	conn  *websocket.Conn
+	admin bool
+	send  chan []CompletionRecord
+}
+
+// queue hands a board to the client's writePump without waiting for it.
+// Only the latest board matters, so one still waiting is replaced. The
+// caller holds the room's mu, which makes it the only sender.
+func (c *leaderboardClient) queue(board []CompletionRecord) {
+	select {
+	case c.send <- board:
+	default:
+		select {
+		case <-c.send:
+		default:
+		}
+		c.send <- board
+	}
+}
+
+func (c *leaderboardClient) writePump() {
+	for board := range c.send {
+		if !c.admin {
+			board = anonymizeCompletions(board)
+		}
+		c.conn.SetWriteDeadline(time.Now().Add(leaderboardWriteTimeout))
+		if err := c.conn.WriteJSON(board); err != nil {
+			c.conn.Close() // ends the handler's read loop
+			return
+		}
+	}
+}
+
+// leaderboardRoom holds the clients of one puzzle. mu is held while a board
+// is read and queued, so every client gets the boards in the order they
+// were read and an older one never follows a newer.
+type leaderboardRoom struct {
+	mu      sync.Mutex
+	clients map[*leaderboardClient]struct{}
+}
+
+// leaderboardRooms maps a puzzle's completions.log path, which is unique
+// across tenants, to its *leaderboardRoom.
+var leaderboardRooms sync.Map
+
+// leaderboard returns the fastest completions of a puzzle.
+func (st *store) leaderboard(folder string) ([]CompletionRecord, error) {
+	records, err := st.loadCompletions(folder)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].DurationSec < records[j].DurationSec
+	})
+	if len(records) > leaderboardSize {
+		records = records[:leaderboardSize]
+	}
+	if records == nil {
+		records = []CompletionRecord{}
+	}
+	return records, nil
+}
+
+// anonymizeCompletions returns a copy of records without player names.
+func anonymizeCompletions(records []CompletionRecord) []CompletionRecord {
+	anonymized := make([]CompletionRecord, len(records))
+	for i, record := range records {
+		record.PlayerName = "Anonymous"
+		anonymized[i] = record
+	}
+	return anonymized
+}
+
+// broadcastLeaderboard sends the current leaderboard of a puzzle to every
+// connected client, if the completion just recorded made it onto the board.
+func broadcastLeaderboard(st *store, folder string, record CompletionRecord) {
+	v, ok := leaderboardRooms.Load(st.completionsPath(folder))
+	if !ok {
+		return
+	}
+	room := v.(*leaderboardRoom)
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	board, err := st.leaderboard(folder)
+	if err != nil {
+		log.Printf("Failed to read leaderboard of %s: %v", folder, err)
+		return
+	}
+	onBoard := slices.ContainsFunc(board, func(b CompletionRecord) bool {
+		return b.DurationSec == record.DurationSec && b.CompletedAt.Equal(record.CompletedAt)
+	})
+	if !onBoard {
+		return // did not make the top ten
+	}
+	for client := range room.clients {
+		client.queue(board)
+	}
+}
+
+// leaderboardSocketHandler pushes a puzzle's top ten completions over a
+// WebSocket: once on connect and again whenever the board changes. Player
+// names are only included for admins, as with /completions.
+func leaderboardSocketHandler(w http.ResponseWriter, r *http.Request) {
+	folder := r.URL.Query().Get("folder")
+	if !validFolderName(folder) {
+		http.Error(w, "Invalid folder name", http.StatusBadRequest)
+		return
+	}
+	st := storeFor(r)
+	board, err := st.leaderboard(folder)
+	if err != nil {
+		http.Error(w, "Error reading completions.log: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return // Upgrade has already replied
+	}
+	client := &leaderboardClient{conn: conn, admin: isAdmin(r), send: make(chan []CompletionRecord, 1)}
+	go client.writePump()
+	v, _ := leaderboardRooms.LoadOrStore(st.completionsPath(folder), &leaderboardRoom{clients: make(map[*leaderboardClient]struct{})})
+	room := v.(*leaderboardRoom)
+
+	// Read the board again under mu: a completion since the first read
+	// may already have been broadcast to the other clients
+	room.mu.Lock()
+	room.clients[client] = struct{}{}
+	if latest, err := st.leaderboard(folder); err == nil {
+		board = latest
+	}
+	client.queue(board)
+	room.mu.Unlock()
+	defer func() {
+		room.mu.Lock()
+		delete(room.clients, client)
+		close(client.send)
+		room.mu.Unlock()
+		conn.Close()
+	}()
+
+	// Clients only listen; reading detects when they go away
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
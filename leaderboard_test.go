@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestLeaderboardQueueKeepsLatest(t *testing.T) {
+	client := &leaderboardClient{send: make(chan []CompletionRecord, 1)}
+	for i := 1; i <= 3; i++ {
+		client.queue(make([]CompletionRecord, i)) // must not block
+	}
+	if board := <-client.send; len(board) != 3 {
+		t.Errorf("queued board of %d records, want the latest of 3", len(board))
+	}
+}
+
+func TestLeaderboardSocketOrder(t *testing.T) {
+	mux, _ := newTestMux(t)
+	mustUpload(t, mux, encodePNG(t, testImage(128, 128, 1)), map[string]string{
+		"name": "foo", "columns": "2", "tileSize": "64",
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/leaderboardSocket?folder=foo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var board []CompletionRecord
+	if err := conn.ReadJSON(&board); err != nil || len(board) != 0 {
+		t.Fatalf("first board %v, %v; want an empty one", board, err)
+	}
+
+	// Completions close together must never reach the client as a newer
+	// board followed by an older, shorter one
+	const completions = 8
+	var wg sync.WaitGroup
+	for i := range completions {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			postJSON(t, mux, "/flagComplete", CompletionRequest{Folder: "foo", PlayerName: "p", DurationSec: 10 + i})
+		}()
+	}
+	wg.Wait()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for last := 0; last < completions; {
+		if err := conn.ReadJSON(&board); err != nil {
+			t.Fatalf("after a board of %d: %v", last, err)
+		}
+		if len(board) < last {
+			t.Fatalf("board of %d records after one of %d", len(board), last)
+		}
+		last = len(board)
+	}
+	if board[0].PlayerName != "Anonymous" {
+		t.Errorf("player name %q shown to a non-admin", board[0].PlayerName)
+	}
+}
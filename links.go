@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+)
+
+// buildLinks returns absolute URLs of the actions available for a puzzle,
+// built from the Host the client used to reach the server.
+func buildLinks(folder string, r *http.Request) map[string]string {
+	scheme := "http"
+	if r.TLS != nil || (*trustProxy && r.Header.Get("X-Forwarded-Proto") == "https") {
+		scheme = "https"
+	}
+	st := storeFor(r)
+	base := scheme + "://" + r.Host + st.prefix
+	q := "?folder=" + url.QueryEscape(folder)
+
+	// The puzzle resource lives under the versioned API root, which is the
+	// tenant's prefix in multi-tenant mode
+	api := base
+	if st.prefix == "" {
+		api += "/api/v1"
+	}
+
+	return map[string]string{
+		"self":     api + "/puzzles/" + url.PathEscape(folder),
+		"preview":  base + "/assemblyPreview" + q,
+		"manifest": base + "/manifest" + q,
+		"delete":   base + "/puzzle" + q,
+		"stats":    base + "/puzzleStats" + q,
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestBuildLinks(t *testing.T) {
+	mux, _ := newTestMux(t)
+	setFlag(t, adminToken, "secret")
+	mustUpload(t, mux, encodePNG(t, testImage(128, 128, 1)), map[string]string{
+		"name": "foo", "columns": "2", "tileSize": "64",
+	})
+	// main serves the API under /api/v1 too, where the self link points
+	root := http.NewServeMux()
+	root.Handle("/", mux)
+	root.Handle("/api/v1/", http.StripPrefix("/api/v1", mux))
+
+	var meta PuzzleMeta
+	if err := json.Unmarshal(get(root, "/puzzleMeta?folder=foo").Body.Bytes(), &meta); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"self":     "http://example.com/api/v1/puzzles/foo",
+		"preview":  "http://example.com/assemblyPreview?folder=foo",
+		"manifest": "http://example.com/manifest?folder=foo",
+		"delete":   "http://example.com/puzzle?folder=foo",
+		"stats":    "http://example.com/puzzleStats?folder=foo",
+	}
+	if len(meta.Links) != len(want) {
+		t.Errorf("links %v, want %v", meta.Links, want)
+	}
+	for rel, href := range want {
+		if meta.Links[rel] != href {
+			t.Errorf("%s: %q, want %q", rel, meta.Links[rel], href)
+		}
+	}
+
+	for _, rel := range []string{"self", "preview", "manifest", "stats"} {
+		u, err := url.Parse(meta.Links[rel])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rec := get(root, u.RequestURI()); rec.Code != http.StatusOK {
+			t.Errorf("GET %s (%s): %d %s", u.RequestURI(), rel, rec.Code, rec.Body)
+		}
+	}
+
+	u, _ := url.Parse(meta.Links["delete"])
+	req := httptest.NewRequest(http.MethodDelete, u.RequestURI(), nil)
+	req.Header.Set("X-Admin-Token", "secret")
+	rec := httptest.NewRecorder()
+	root.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("DELETE %s: %d %s", u.RequestURI(), rec.Code, rec.Body)
+	}
+	if rec := get(root, "/api/v1/puzzles/foo"); rec.Code != http.StatusNotFound {
+		t.Errorf("self after delete: %d, want 404", rec.Code)
+	}
+}
+
+func TestBuildLinksTenant(t *testing.T) {
+	st := &store{prefix: "/api/v1/acme"}
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/acme/puzzleMeta?folder=foo", nil)
+	r = r.WithContext(context.WithValue(r.Context(), storeContextKey{}, st))
+	links := buildLinks("foo", r)
+	if links["self"] != "http://example.com/api/v1/acme/puzzles/foo" {
+		t.Errorf("self %q", links["self"])
+	}
+	if links["stats"] != "http://example.com/api/v1/acme/puzzleStats?folder=foo" {
+		t.Errorf("stats %q", links["stats"])
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// lint returns the problems found in a manifest that has not been written
+// by the slicer, such as one generated by a script.
+func (m *Manifest) lint() []string {
+	var problems []string
+	if m.SchemaVersion < 0 || m.SchemaVersion > manifestSchemaVersion {
+		problems = append(problems, fmt.Sprintf("unrecognised schemaVersion %d", m.SchemaVersion))
+	}
+	if m.TileSize <= 0 {
+		problems = append(problems, "tileSize must be positive")
+	}
+	if m.Rows <= 0 || m.Cols <= 0 {
+		problems = append(problems, "rows and cols must be positive")
+	} else if m.Rows*m.Cols != len(m.Solution) {
+		problems = append(problems, fmt.Sprintf("rows × cols is %d but solution has %d entries", m.Rows*m.Cols, len(m.Solution)))
+	}
+
+	// Check positions in order so the problems come out the same every time
+	positions := make([]string, 0, len(m.Solution))
+	for pos := range m.Solution {
+		positions = append(positions, pos)
+	}
+	sort.Strings(positions)
+	inSolution := make(map[string]string)
+	for _, pos := range positions {
+		file := m.Solution[pos]
+		var row, col int
+		if _, err := fmt.Sscanf(pos, "%d,%d", &row, &col); err != nil || fmt.Sprintf("%d,%d", row, col) != pos {
+			problems = append(problems, fmt.Sprintf("solution key %q is not \"row,col\"", pos))
+		} else if m.Rows > 0 && m.Cols > 0 && (row < 0 || row >= m.Rows || col < 0 || col >= m.Cols) {
+			problems = append(problems, fmt.Sprintf("solution position %s is outside the %d×%d grid", pos, m.Rows, m.Cols))
+		}
+		if other, ok := inSolution[file]; ok {
+			problems = append(problems, fmt.Sprintf("%s is used at both %s and %s", file, other, pos))
+		} else {
+			inSolution[file] = pos
+		}
+	}
+
+	for _, piece := range m.Pieces {
+		if _, ok := inSolution[piece.File]; !ok {
+			problems = append(problems, fmt.Sprintf("piece %s does not appear in solution", piece.File))
+		}
+	}
+	return problems
+}
+
+// lintManifestHandler validates a manifest posted as the request body
+// without storing it.
+func lintManifestHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var problems []string
+	var manifest Manifest
+	if err := json.NewDecoder(r.Body).Decode(&manifest); err != nil {
+		problems = []string{"invalid JSON: " + err.Error()}
+	} else {
+		problems = manifest.lint()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if len(problems) == 0 {
+		json.NewEncoder(w).Encode(map[string]any{"valid": true})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]any{"valid": false, "errors": problems})
+}
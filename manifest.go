@@ -0,0 +1,484 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type PieceInfo struct {
+	File      string `json:"file"`
+	SizeBytes int64  `json:"sizeBytes,omitempty"`
+}
+
+// manifestSchemaVersion is the schemaVersion written to new manifests.
+// Manifests from before versioning have none.
+const manifestSchemaVersion = 1
+
+// Manifest is the content of images/<folder>/manifest.json.
+type Manifest struct {
+	SchemaVersion       int                       `json:"schemaVersion,omitempty"`
+	Pieces              []PieceInfo               `json:"pieces"`
+	Solution            map[string]string         `json:"solution"` // "row,col":"filename"
+	TileSize            int                       `json:"tileSize,omitempty"`
+	Rows                int                       `json:"rows,omitempty"`
+	Cols                int                       `json:"cols,omitempty"`
+	ResizeAlgorithm     string                    `json:"resizeAlgorithm,omitempty"`
+	IndexFormat         string                    `json:"indexFormat,omitempty"` // "jpeg" or "png"
+	UploaderIP          string                    `json:"uploaderIP,omitempty"`  // hashIP of the uploader's IP
+	TileNameTemplate    string                    `json:"tileNameTemplate,omitempty"`
+	TileFormat          string                    `json:"tileFormat,omitempty"`
+	Description         string                    `json:"description,omitempty"`
+	Tags                []string                  `json:"tags,omitempty"`
+	Difficulty          string                    `json:"difficulty,omitempty"`
+	Grayscale           bool                      `json:"grayscale,omitempty"`
+	Entropy             float64                   `json:"entropy,omitempty"`        // grayscale Shannon entropy, bits per pixel
+	PieceEntropies      map[string]float64        `json:"pieceEntropies,omitempty"` // per tile, keyed by filename
+	CreatedAt           time.Time                 `json:"createdAt,omitempty"`
+	Neighbors           map[string]PieceNeighbors `json:"neighbors,omitempty"` // keyed by filename
+	TotalPieceSizeBytes int64                     `json:"totalPieceSizeBytes,omitempty"`
+	Resolutions         map[string]string         `json:"resolutions,omitempty"` // tile size in px: pieces directory
+	ColumnWidths        []int                     `json:"columnWidths,omitempty"`
+	DenoiseRadius       int                       `json:"denoiseRadius,omitempty"`
+}
+
+// loadManifest reads and parses the manifest.json of a puzzle folder.
+func (st *store) loadManifest(folder string) (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(st.puzzlePath(folder), "manifest.json"))
+	if err != nil {
+		return nil, err
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, err
+	}
+	return &manifest, nil
+}
+
+// PieceNeighbors names the tiles that share an edge with a tile in the
+// solved puzzle.
+type PieceNeighbors struct {
+	Top    string `json:"top,omitempty"`
+	Bottom string `json:"bottom,omitempty"`
+	Left   string `json:"left,omitempty"`
+	Right  string `json:"right,omitempty"`
+}
+
+// buildNeighbors looks up the edge neighbours of every tile in a solution.
+func buildNeighbors(solution map[string]string) map[string]PieceNeighbors {
+	at := func(r, c int) string {
+		return solution[fmt.Sprintf("%d,%d", r, c)]
+	}
+	neighbors := make(map[string]PieceNeighbors, len(solution))
+	for pos, file := range solution {
+		var r, c int
+		fmt.Sscanf(pos, "%d,%d", &r, &c)
+		neighbors[file] = PieceNeighbors{
+			Top:    at(r-1, c),
+			Bottom: at(r+1, c),
+			Left:   at(r, c-1),
+			Right:  at(r, c+1),
+		}
+	}
+	return neighbors
+}
+
+// manifestMutex serialises read-modify-write updates of manifest.json files.
+var manifestMutex sync.Mutex
+
+// writeManifest replaces the manifest.json of a puzzle folder. The file is
+// kept compact, without a trailing newline, so it diffs cleanly.
+func (st *store) writeManifest(folder string, manifest *Manifest) error {
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(st.puzzlePath(folder), "manifest.json"), data, 0644)
+}
+
+// manifestHandler serves GET /manifest?folder=<name>, indented with
+// ?pretty=true. The solution, the neighbours that give it away and the
+// uploader hash are only included for admins and holders of a share token
+// for the puzzle.
+func manifestHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	folder := r.URL.Query().Get("folder")
+	if !validFolderName(folder) {
+		http.Error(w, "Invalid folder name", http.StatusBadRequest)
+		return
+	}
+	pretty, err := strconv.ParseBool(formValueOr(r, "pretty", "false"))
+	if err != nil {
+		http.Error(w, "Invalid pretty: must be true or false", http.StatusBadRequest)
+		return
+	}
+
+	manifest, err := storeFor(r).loadManifest(folder)
+	if err != nil {
+		http.Error(w, "Error reading manifest.json: "+err.Error(), http.StatusNotFound)
+		return
+	}
+
+	var v any = manifest
+	if !canSeeSolution(r, folder) {
+		// The outer fields hide the embedded ones from encoding/json
+		v = struct {
+			*Manifest
+			Solution   *struct{} `json:"solution,omitempty"`
+			Neighbors  *struct{} `json:"neighbors,omitempty"`
+			UploaderIP *struct{} `json:"uploaderIP,omitempty"`
+		}{Manifest: manifest}
+	}
+
+	var data []byte
+	if pretty {
+		data, err = json.MarshalIndent(v, "", "  ")
+	} else {
+		data, err = json.Marshal(v)
+	}
+	if err != nil {
+		http.Error(w, "Error encoding manifest: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// setPieceSize records the new size of a rewritten tile and updates the
+// total. Manifests written before sizes were recorded are left alone.
+func (m *Manifest) setPieceSize(file string, size int64) {
+	if m.TotalPieceSizeBytes == 0 {
+		return
+	}
+	for i := range m.Pieces {
+		if m.Pieces[i].File == file {
+			m.TotalPieceSizeBytes += size - m.Pieces[i].SizeBytes
+			m.Pieces[i].SizeBytes = size
+		}
+	}
+}
+
+// setPieceEntropy records the entropy of a rewritten tile, if the puzzle
+// has per-tile entropies.
+func (m *Manifest) setPieceEntropy(file string, img image.Image) {
+	if m.PieceEntropies != nil {
+		m.PieceEntropies[file] = imageEntropy(img)
+	}
+}
+
+// recordPieceRewrite stores the new size of a rewritten tile in
+// manifest.json, and its new entropy unless img is nil. Callers pass nil
+// when the rewrite keeps the tile's histogram, as rotation does.
+func (st *store) recordPieceRewrite(folder, file string, size int64, img image.Image) error {
+	manifestMutex.Lock()
+	defer manifestMutex.Unlock()
+
+	manifest, err := st.loadManifest(folder)
+	if err != nil {
+		return err
+	}
+	if manifest.TotalPieceSizeBytes == 0 && (img == nil || manifest.PieceEntropies == nil) {
+		return nil
+	}
+	manifest.setPieceSize(file, size)
+	if img != nil {
+		manifest.setPieceEntropy(file, img)
+	}
+	return st.writeManifest(folder, manifest)
+}
+
+// unknownPieces returns, sorted and without repeats, the files that are not
+// pieces of the puzzle.
+func (m *Manifest) unknownPieces(files []string) []string {
+	pieces := make(map[string]bool, len(m.Pieces))
+	for _, piece := range m.Pieces {
+		pieces[piece.File] = true
+	}
+	unknown := []string{}
+	for _, file := range files {
+		if !pieces[file] && !slices.Contains(unknown, file) {
+			unknown = append(unknown, file)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+// writeUnknownPieces rejects a request that names files which are not
+// pieces of the puzzle.
+func writeUnknownPieces(w http.ResponseWriter, status int, unknown []string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{"error": "Unknown tile files", "unknownFiles": unknown})
+}
+
+// columnX returns the x offset of the left edge of a column. Columns all
+// have the tile size as width unless the manifest lists columnWidths.
+func (m *Manifest) columnX(col int) int {
+	if len(m.ColumnWidths) == 0 {
+		return col * m.effectiveTileSize()
+	}
+	x := 0
+	for c := 0; c < col; c++ {
+		if c < len(m.ColumnWidths) {
+			x += m.ColumnWidths[c]
+		} else {
+			x += m.effectiveTileSize()
+		}
+	}
+	return x
+}
+
+// gridSize returns the puzzle dimensions, falling back to the extent of the
+// solution map for manifests written before rows and cols were recorded.
+func (m *Manifest) gridSize() (rows, cols int) {
+	if m.Rows > 0 && m.Cols > 0 {
+		return m.Rows, m.Cols
+	}
+	for pos := range m.Solution {
+		var r, c int
+		fmt.Sscanf(pos, "%d,%d", &r, &c)
+		if r+1 > rows {
+			rows = r + 1
+		}
+		if c+1 > cols {
+			cols = c + 1
+		}
+	}
+	return rows, cols
+}
+
+// effectiveTileSize returns the tile size of the puzzle, which is 512 for
+// manifests written before the tile size was recorded.
+func (m *Manifest) effectiveTileSize() int {
+	if m.TileSize > 0 {
+		return m.TileSize
+	}
+	return 512
+}
+
+// computeDifficulty grades a puzzle by its number of pieces. When the image
+// entropy is known the piece count is weighted by it, so busy images reach
+// Expert with fewer pieces and flat ones need more; 6 bits is neutral.
+func computeDifficulty(rows, cols int, entropy float64) string {
+	pieces := float64(rows * cols)
+	if entropy > 0 {
+		pieces *= entropy / 6
+	}
+	switch {
+	case pieces <= 16:
+		return "Easy"
+	case pieces <= 64:
+		return "Medium"
+	case pieces <= 144:
+		return "Hard"
+	default:
+		return "Expert"
+	}
+}
+
+type ManifestDiffRequest struct {
+	FolderA string `json:"folderA"`
+	FolderB string `json:"folderB"`
+}
+
+type ManifestDiff struct {
+	Changed       []string `json:"changed"`
+	AddedPieces   []string `json:"addedPieces"`
+	RemovedPieces []string `json:"removedPieces"`
+	ChangedPieces []string `json:"changedPieces"` // positions holding a different filename
+}
+
+// diffManifests compares the slicing parameters of two manifests and their
+// pieces by solution position.
+func diffManifests(a, b *Manifest) ManifestDiff {
+	diff := ManifestDiff{
+		Changed:       []string{},
+		AddedPieces:   []string{},
+		RemovedPieces: []string{},
+		ChangedPieces: []string{},
+	}
+	if a.TileSize != b.TileSize {
+		diff.Changed = append(diff.Changed, "tileSize")
+	}
+	if a.Rows != b.Rows {
+		diff.Changed = append(diff.Changed, "rows")
+	}
+	if a.Cols != b.Cols {
+		diff.Changed = append(diff.Changed, "cols")
+	}
+	if a.ResizeAlgorithm != b.ResizeAlgorithm {
+		diff.Changed = append(diff.Changed, "resizeAlgorithm")
+	}
+
+	for pos, fileB := range b.Solution {
+		fileA, ok := a.Solution[pos]
+		if !ok {
+			diff.AddedPieces = append(diff.AddedPieces, pos)
+		} else if fileA != fileB {
+			diff.ChangedPieces = append(diff.ChangedPieces, pos)
+		}
+	}
+	for pos := range a.Solution {
+		if _, ok := b.Solution[pos]; !ok {
+			diff.RemovedPieces = append(diff.RemovedPieces, pos)
+		}
+	}
+	sort.Strings(diff.AddedPieces)
+	sort.Strings(diff.RemovedPieces)
+	sort.Strings(diff.ChangedPieces)
+	return diff
+}
+
+func manifestDiffHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req ManifestDiffRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !validFolderName(req.FolderA) || !validFolderName(req.FolderB) {
+		http.Error(w, "Invalid folder name", http.StatusBadRequest)
+		return
+	}
+	if !requirePuzzleVisible(w, r, req.FolderA) {
+		return
+	}
+	if !requirePuzzleVisible(w, r, req.FolderB) {
+		return
+	}
+
+	st := storeFor(r)
+	manifestA, err := st.loadManifest(req.FolderA)
+	if err != nil {
+		http.Error(w, "Error reading manifest for "+req.FolderA+": "+err.Error(), http.StatusNotFound)
+		return
+	}
+	manifestB, err := st.loadManifest(req.FolderB)
+	if err != nil {
+		http.Error(w, "Error reading manifest for "+req.FolderB+": "+err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(diffManifests(manifestA, manifestB))
+}
+
+// PuzzleMeta is the public description of a puzzle. It deliberately leaves
+// out the solution, and the neighbours unless canSeeSolution, so it can be
+// served without authentication.
+type PuzzleMeta struct {
+	Name        string                    `json:"name"`
+	DisplayName string                    `json:"displayName"`
+	Description string                    `json:"description"`
+	Rows        int                       `json:"rows"`
+	Cols        int                       `json:"cols"`
+	TileSize    int                       `json:"tileSize"`
+	TileFormat  string                    `json:"tileFormat"`
+	Difficulty  string                    `json:"difficulty"`
+	Grayscale   bool                      `json:"grayscale"`
+	Entropy     float64                   `json:"entropy,omitempty"`
+	CreatedAt   time.Time                 `json:"createdAt"`
+	PlayCount   int                       `json:"playCount"`
+	Tags        []string                  `json:"tags"`
+	Pieces      []string                  `json:"pieces"`
+	Neighbors   map[string]PieceNeighbors `json:"neighbors,omitempty"`
+	Links       map[string]string         `json:"_links"`
+}
+
+func puzzleMetaHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	folder := r.URL.Query().Get("folder")
+	if !validFolderName(folder) {
+		http.Error(w, "Invalid folder name", http.StatusBadRequest)
+		return
+	}
+
+	st := storeFor(r)
+	manifest, err := st.loadManifest(folder)
+	if err != nil {
+		http.Error(w, "Error reading manifest.json: "+err.Error(), http.StatusNotFound)
+		return
+	}
+	completions, err := st.loadCompletions(folder)
+	if err != nil {
+		http.Error(w, "Error reading completions.log: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rows, cols := manifest.gridSize()
+	meta := PuzzleMeta{
+		Name:        folder,
+		DisplayName: folder,
+		Description: manifest.Description,
+		Rows:        rows,
+		Cols:        cols,
+		TileSize:    manifest.effectiveTileSize(),
+		TileFormat:  manifest.TileFormat,
+		Difficulty:  manifest.Difficulty,
+		Grayscale:   manifest.Grayscale,
+		Entropy:     manifest.Entropy,
+		CreatedAt:   manifest.CreatedAt,
+		PlayCount:   len(completions),
+		Tags:        manifest.Tags,
+		Pieces:      []string{},
+		Links:       buildLinks(folder, r),
+	}
+	if canSeeSolution(r, folder) {
+		meta.Neighbors = manifest.Neighbors
+	}
+	if entry, ok, err := st.findImageEntry(folder); err == nil && ok {
+		meta.DisplayName = entry.Name
+	}
+	if meta.TileFormat == "" {
+		meta.TileFormat = "png"
+	}
+	if meta.Difficulty == "" {
+		meta.Difficulty = computeDifficulty(rows, cols, manifest.Entropy)
+	}
+	if meta.Tags == nil {
+		meta.Tags = []string{}
+	}
+	for _, piece := range manifest.Pieces {
+		meta.Pieces = append(meta.Pieces, piece.File)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(meta)
+}
+
+// puzzleResourceHandler serves /puzzles/<folder>, the puzzle's self link:
+// GET describes it as /puzzleMeta does and DELETE removes it as /puzzle does.
+func puzzleResourceHandler(w http.ResponseWriter, r *http.Request) {
+	r2 := r.Clone(r.Context())
+	q := r2.URL.Query()
+	q.Set("folder", strings.TrimPrefix(r.URL.Path, "/puzzles/"))
+	r2.URL.RawQuery = q.Encode()
+
+	if r.Method == http.MethodDelete {
+		requirePuzzleAccess(puzzleHandler)(w, r2)
+		return
+	}
+	requirePuzzleAccess(puzzleMetaHandler)(w, r2)
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestManifestRoundTrip(t *testing.T) {
+	want := &Manifest{
+		SchemaVersion: manifestSchemaVersion,
+		Pieces: []PieceInfo{
+			{File: "tile_0_0.png", SizeBytes: 100},
+			{File: "tile_0_1.png", SizeBytes: 200},
+		},
+		Solution:            map[string]string{"0,0": "tile_0_0.png", "0,1": "tile_0_1.png"},
+		TileSize:            256,
+		Rows:                1,
+		Cols:                2,
+		ResizeAlgorithm:     "lanczos3",
+		IndexFormat:         "png",
+		UploaderIP:          "abc123",
+		TileNameTemplate:    "tile_{{.Row}}_{{.Col}}",
+		TileFormat:          "png",
+		Description:         "A test puzzle",
+		Tags:                []string{"a", "b"},
+		Difficulty:          "easy",
+		Grayscale:           true,
+		Entropy:             6.25,
+		PieceEntropies:      map[string]float64{"tile_0_0.png": 6, "tile_0_1.png": 6.5},
+		CreatedAt:           time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC),
+		Neighbors:           buildNeighbors(map[string]string{"0,0": "tile_0_0.png", "0,1": "tile_0_1.png"}),
+		TotalPieceSizeBytes: 300,
+		Resolutions:         map[string]string{"256": "pieces", "128": "pieces_128"},
+		ColumnWidths:        []int{256, 256},
+		DenoiseRadius:       1,
+	}
+
+	// A field left out here would round-trip as its zero value unnoticed
+	v := reflect.ValueOf(want).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if v.Field(i).IsZero() {
+			t.Errorf("%s is not populated", v.Type().Field(i).Name)
+		}
+	}
+
+	data, err := json.Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got Manifest
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&got, want) {
+		t.Errorf("JSON round trip:\ngot  %+v\nwant %+v", got, *want)
+	}
+
+	st := &store{root: t.TempDir()}
+	if err := os.MkdirAll(st.puzzlePath("foo"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.writeManifest("foo", want); err != nil {
+		t.Fatal(err)
+	}
+	read, err := st.loadManifest("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, want) {
+		t.Errorf("file round trip:\ngot  %+v\nwant %+v", *read, *want)
+	}
+}
+
+func TestManifestHandler(t *testing.T) {
+	mux, _ := newTestMux(t)
+	setFlag(t, adminToken, "secret")
+	mustUpload(t, mux, encodePNG(t, testImage(128, 128, 1)), map[string]string{
+		"name": "foo", "columns": "2", "tileSize": "64", "computeNeighbors": "true",
+	})
+
+	t.Run("compact", func(t *testing.T) {
+		rec := get(mux, "/manifest?folder=foo")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body)
+		}
+		if strings.Contains(rec.Body.String(), "\n") {
+			t.Errorf("compact manifest contains a newline: %q", rec.Body)
+		}
+	})
+
+	t.Run("pretty", func(t *testing.T) {
+		rec := get(mux, "/manifest?folder=foo&pretty=true")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body)
+		}
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, rec.Body.Bytes(), "", "  "); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Body.String() != indented.String() {
+			t.Errorf("pretty manifest is not indented:\n%s", rec.Body)
+		}
+	})
+
+	t.Run("invalid pretty", func(t *testing.T) {
+		if rec := get(mux, "/manifest?folder=foo&pretty=maybe"); rec.Code != http.StatusBadRequest {
+			t.Errorf("status %d, want 400", rec.Code)
+		}
+	})
+
+	hasSolution := func(rec *httptest.ResponseRecorder) bool {
+		var m map[string]any
+		if err := json.Unmarshal(rec.Body.Bytes(), &m); err != nil {
+			t.Fatal(err)
+		}
+		_, solution := m["solution"]
+		_, neighbors := m["neighbors"]
+		if solution != neighbors {
+			t.Errorf("solution included: %v, neighbors included: %v", solution, neighbors)
+		}
+		return solution
+	}
+
+	t.Run("public", func(t *testing.T) {
+		if hasSolution(get(mux, "/manifest?folder=foo")) {
+			t.Error("solution served without a token")
+		}
+	})
+
+	t.Run("admin", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/manifest?folder=foo", nil)
+		req.Header.Set("X-Admin-Token", "secret")
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if !hasSolution(rec) {
+			t.Error("solution missing for an admin")
+		}
+	})
+
+	t.Run("share token", func(t *testing.T) {
+		token := shareTokenFor(t, mux, "foo")
+		if !hasSolution(get(mux, "/manifest?folder=foo&token="+token)) {
+			t.Error("solution missing with a share token")
+		}
+		if hasSolution(get(mux, "/manifest?folder=foo&token="+token+"x")) {
+			t.Error("solution served with a forged token")
+		}
+	})
+}
+
+// TestSolutionHiddenElsewhere checks that the routes besides /manifest keep
+// to its policy: whatever gives away where tiles belong is for admins and
+// share token holders only.
+func TestSolutionHiddenElsewhere(t *testing.T) {
+	mux, st := newTestMux(t)
+	setFlag(t, adminToken, "secret")
+	mustUpload(t, mux, encodePNG(t, testImage(128, 128, 1)), map[string]string{
+		"name": "foo", "columns": "2", "tileSize": "64", "computeNeighbors": "true",
+	})
+	manifest, err := st.loadManifest("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := manifest.Solution["1,0"]
+	token := shareTokenFor(t, mux, "foo")
+
+	t.Run("puzzleMeta", func(t *testing.T) {
+		hasNeighbors := func(rec *httptest.ResponseRecorder) bool {
+			var meta map[string]any
+			if err := json.Unmarshal(rec.Body.Bytes(), &meta); err != nil {
+				t.Fatal(err)
+			}
+			_, ok := meta["neighbors"]
+			return ok
+		}
+		if hasNeighbors(get(mux, "/puzzleMeta?folder=foo")) {
+			t.Error("neighbors served without a token")
+		}
+		if !hasNeighbors(get(mux, "/puzzleMeta?folder=foo&token="+token)) {
+			t.Error("neighbors missing with a share token")
+		}
+	})
+
+	t.Run("tileAltText", func(t *testing.T) {
+		if body := get(mux, "/tileAltText?folder=foo&file="+file).Body.String(); strings.Contains(body, "row") {
+			t.Errorf("alt text without a token gives the position: %s", body)
+		}
+		if body := get(mux, "/tileAltText?folder=foo&file="+file+"&token="+token).Body.String(); !strings.Contains(body, "row 2, column 1") {
+			t.Errorf("alt text with a share token lacks the position: %s", body)
+		}
+	})
+
+	t.Run("embed", func(t *testing.T) {
+		rec := get(mux, "/embed?folder=foo&token="+token)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body)
+		}
+		if body := rec.Body.String(); strings.Contains(body, "solution\"") || strings.Contains(body, `"1,0"`) {
+			t.Errorf("embed page inlines the solution:\n%s", body)
+		}
+	})
+}
+
+// shareTokenFor asks /shareToken for a token to folder. The admin token
+// must be "secret".
+func shareTokenFor(t *testing.T, h http.Handler, folder string) string {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/shareToken?folder="+folder, nil)
+	req.Header.Set("X-Admin-Token", "secret")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	var resp struct{ Token string }
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Token == "" {
+		t.Fatalf("shareToken: %d %s", rec.Code, rec.Body)
+	}
+	return resp.Token
+}
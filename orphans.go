@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+)
+
+// isOrphan reports whether an imageIndex.json entry has lost its puzzle
+// directory, e.g. because someone deleted it by hand. The directory of a
+// soft-deleted entry is expected in the trash.
+func (st *store) isOrphan(entry ImageEntry) bool {
+	path := st.puzzlePath(entry.Folder)
+	if entry.Deleted {
+		path = st.trashPath(entry.Folder)
+	}
+	info, err := os.Stat(path)
+	return err != nil || !info.IsDir()
+}
+
+// orphanedEntries returns the imageIndex.json entries whose directory is
+// missing. The caller must hold imageIndexMutex.
+func (st *store) orphanedEntries() ([]ImageEntry, error) {
+	imageIndex, err := st.loadImageIndex()
+	if err != nil {
+		return nil, err
+	}
+	orphans := []ImageEntry{}
+	for _, entry := range imageIndex.Images {
+		if st.isOrphan(entry) {
+			orphans = append(orphans, entry)
+		}
+	}
+	return orphans, nil
+}
+
+// warnOrphans logs a warning for every orphaned entry of every store.
+func warnOrphans() {
+	imageIndexMutex.Lock()
+	defer imageIndexMutex.Unlock()
+	for _, st := range allStores() {
+		orphans, err := st.orphanedEntries()
+		if err != nil {
+			log.Printf("WARNING: cannot read %s: %v", st.imageIndexPath(), err)
+			continue
+		}
+		for _, entry := range orphans {
+			log.Printf("WARNING: imageIndex.json entry %q has no directory %s", entry.Name, st.puzzlePath(entry.Folder))
+		}
+	}
+}
+
+func orphanedEntriesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	imageIndexMutex.Lock()
+	orphans, err := storeFor(r).orphanedEntries()
+	imageIndexMutex.Unlock()
+	if err != nil {
+		http.Error(w, "Error reading imageIndex.json: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(orphans)
+}
+
+// pruneOrphansHandler removes the orphaned entries from imageIndex.json and
+// returns them.
+func pruneOrphansHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	st := storeFor(r)
+	imageIndexMutex.Lock()
+	defer imageIndexMutex.Unlock()
+
+	imageIndex, err := st.loadImageIndex()
+	if err != nil {
+		http.Error(w, "Error reading imageIndex.json: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	removed := []ImageEntry{}
+	kept := imageIndex.Images[:0]
+	for _, entry := range imageIndex.Images {
+		if st.isOrphan(entry) {
+			removed = append(removed, entry)
+		} else {
+			kept = append(kept, entry)
+		}
+	}
+	imageIndex.Images = kept
+
+	if len(removed) > 0 {
+		if err := st.saveImageIndex(imageIndex); err != nil {
+			http.Error(w, "Error writing imageIndex.json: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"removed": removed})
+}
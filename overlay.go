@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const maxOverlayLineWidth = 64
+
+// parseRGBA parses a CSS style "rgba(r,g,b,a)" colour. The channels are
+// 0-255; the alpha may also be given as a fraction between 0 and 1.
+func parseRGBA(s string) (color.NRGBA, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "rgba(") || !strings.HasSuffix(s, ")") {
+		return color.NRGBA{}, fmt.Errorf("colour %q is not of the form rgba(r,g,b,a)", s)
+	}
+	parts := strings.Split(s[len("rgba("):len(s)-1], ",")
+	if len(parts) != 4 {
+		return color.NRGBA{}, fmt.Errorf("colour %q must have four components", s)
+	}
+
+	var channels [4]uint8
+	for i, part := range parts {
+		part = strings.TrimSpace(part)
+		if i == 3 && strings.Contains(part, ".") {
+			f, err := strconv.ParseFloat(part, 64)
+			if err != nil || f < 0 || f > 1 {
+				return color.NRGBA{}, fmt.Errorf("invalid alpha %q", part)
+			}
+			channels[i] = uint8(f*255 + 0.5)
+			continue
+		}
+		v, err := strconv.Atoi(part)
+		if err != nil || v < 0 || v > 255 {
+			return color.NRGBA{}, fmt.Errorf("invalid colour component %q", part)
+		}
+		channels[i] = uint8(v)
+	}
+	return color.NRGBA{R: channels[0], G: channels[1], B: channels[2], A: channels[3]}, nil
+}
+
+// gridOverlayHandler returns a transparent PNG the size of the assembled
+// puzzle with only the tile boundaries drawn on it.
+func gridOverlayHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	folder := query.Get("folder")
+	if !validFolderName(folder) {
+		http.Error(w, "Invalid folder name", http.StatusBadRequest)
+		return
+	}
+
+	lineWidth := 2
+	if s := query.Get("lineWidth"); s != "" {
+		var err error
+		lineWidth, err = strconv.Atoi(s)
+		if err != nil || lineWidth <= 0 || lineWidth > maxOverlayLineWidth {
+			http.Error(w, fmt.Sprintf("Invalid lineWidth: must be between 1 and %d", maxOverlayLineWidth), http.StatusBadRequest)
+			return
+		}
+	}
+
+	lineColor := color.NRGBA{A: 255}
+	if s := query.Get("color"); s != "" {
+		var err error
+		lineColor, err = parseRGBA(s)
+		if err != nil {
+			http.Error(w, "Invalid color: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	manifest, err := storeFor(r).loadManifest(folder)
+	if err != nil {
+		http.Error(w, "Error reading manifest.json: "+err.Error(), http.StatusNotFound)
+		return
+	}
+	rows, cols := manifest.gridSize()
+	tileSize := manifest.effectiveTileSize()
+	canvasW := manifest.columnX(cols)
+	canvasH := rows * tileSize
+
+	overlay := image.NewNRGBA(image.Rect(0, 0, canvasW, canvasH))
+	src := image.NewUniform(lineColor)
+	// Lines are centred on each boundary and clipped at the outer edges
+	half := lineWidth / 2
+	for c := 0; c <= cols; c++ {
+		x := manifest.columnX(c) - half
+		draw.Draw(overlay, image.Rect(x, 0, x+lineWidth, canvasH), src, image.Point{}, draw.Src)
+	}
+	for r := 0; r <= rows; r++ {
+		y := r*tileSize - half
+		draw.Draw(overlay, image.Rect(0, y, canvasW, y+lineWidth), src, image.Point{}, draw.Src)
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	if err := png.Encode(w, overlay); err != nil {
+		http.Error(w, "Failed to encode PNG: "+err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"math/bits"
+	"net/http"
+	"strconv"
+)
+
+// duplicateDistance is the largest Hamming distance between the dHashes of
+// two images that are taken to be the same picture.
+const duplicateDistance = 5
+
+// dHash computes the difference hash of img: it is shrunk to 9x8 grayscale
+// pixels and each bit records whether a pixel is brighter than its right
+// neighbour. Recompressed or rescaled copies of an image hash alike.
+func dHash(img image.Image) uint64 {
+	small := resizeImage(img, 9, 8)
+	b := small.Bounds()
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			left := color.GrayModel.Convert(small.At(b.Min.X+x, b.Min.Y+y)).(color.Gray).Y
+			right := color.GrayModel.Convert(small.At(b.Min.X+x+1, b.Min.Y+y)).(color.Gray).Y
+			hash <<= 1
+			if left > right {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+func formatPHash(hash uint64) string {
+	return fmt.Sprintf("%016x", hash)
+}
+
+type pendingUpload struct {
+	root   string
+	hash   uint64
+	folder string
+}
+
+// pendingUploads are uploads that passed the duplicate check but are not in
+// imageIndex.json yet. Guarded by imageIndexMutex.
+var pendingUploads = map[*pendingUpload]struct{}{}
+
+// claimPHash checks that no live puzzle of the store, and no upload in
+// progress, looks like an image with the given hash, and reserves the hash
+// for folder until release is called. A duplicate is reported as the
+// existing folder name.
+func (st *store) claimPHash(hash uint64, folder string) (existing string, release func(), err error) {
+	imageIndexMutex.Lock()
+	defer imageIndexMutex.Unlock()
+
+	imageIndex, err := st.loadImageIndex()
+	if err != nil {
+		return "", nil, err
+	}
+	for _, entry := range imageIndex.Images {
+		if entry.Deleted || entry.PHash == "" {
+			continue
+		}
+		other, err := strconv.ParseUint(entry.PHash, 16, 64)
+		if err == nil && bits.OnesCount64(hash^other) <= duplicateDistance {
+			return entry.Folder, nil, nil
+		}
+	}
+	for p := range pendingUploads {
+		if p.root == st.root && bits.OnesCount64(hash^p.hash) <= duplicateDistance {
+			return p.folder, nil, nil
+		}
+	}
+
+	p := &pendingUpload{root: st.root, hash: hash, folder: folder}
+	pendingUploads[p] = struct{}{}
+	return "", func() {
+		imageIndexMutex.Lock()
+		delete(pendingUploads, p)
+		imageIndexMutex.Unlock()
+	}, nil
+}
+
+// writeDuplicate rejects an upload of an image the store already has.
+func writeDuplicate(w http.ResponseWriter, existing string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(map[string]string{"error": "duplicate", "existingFolder": existing})
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image/jpeg"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+func TestUploadRejectsDuplicates(t *testing.T) {
+	mux, _ := newTestMux(t)
+	original := testImage(256, 256, 1)
+	mustUpload(t, mux, encodePNG(t, original), map[string]string{"name": "foo", "columns": "2", "tileSize": "64"})
+
+	var recompressed bytes.Buffer
+	if err := jpeg.Encode(&recompressed, original, &jpeg.Options{Quality: 60}); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		image []byte
+	}{
+		{"same image", encodePNG(t, original)},
+		{"recompressed", recompressed.Bytes()},
+		{"rescaled", encodePNG(t, resizeImage(original, 192, 192))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := uploadTestPuzzle(t, mux, tt.image, map[string]string{"name": "copy", "columns": "2", "tileSize": "64"})
+			if rec.Code != http.StatusConflict {
+				t.Fatalf("status %d, want 409: %s", rec.Code, rec.Body)
+			}
+			var resp struct{ Error, ExistingFolder string }
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Error != "duplicate" || resp.ExistingFolder != "foo" {
+				t.Errorf("got %+v, want a duplicate of foo", resp)
+			}
+		})
+	}
+
+	t.Run("different image", func(t *testing.T) {
+		mustUpload(t, mux, encodePNG(t, testImage(256, 256, 2)), map[string]string{"name": "bar", "columns": "2", "tileSize": "64"})
+	})
+}
+
+func TestConcurrentDuplicateUploads(t *testing.T) {
+	mux, _ := newTestMux(t)
+	image := encodePNG(t, testImage(256, 256, 1))
+
+	var wg sync.WaitGroup
+	codes := make([]int, 2)
+	for i, name := range []string{"foo", "bar"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes[i] = uploadTestPuzzle(t, mux, image, map[string]string{"name": name, "columns": "2", "tileSize": "64"}).Code
+		}()
+	}
+	wg.Wait()
+
+	if !(codes[0] == http.StatusOK && codes[1] == http.StatusConflict) && !(codes[0] == http.StatusConflict && codes[1] == http.StatusOK) {
+		t.Errorf("statuses %v, want one 200 and one 409", codes)
+	}
+}
@@ -0,0 +1,437 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/draw"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+
+	"github.com/nfnt/resize"
+)
+
+// cropToFill scales img so that it covers a width×height box and crops the
+// overflow evenly from both sides, keeping the centre of the image.
+func cropToFill(img image.Image, width, height int) *image.RGBA {
+	b := img.Bounds()
+	if b.Dx() < width || b.Dy() < height {
+		scale := max(float64(width)/float64(b.Dx()), float64(height)/float64(b.Dy()))
+		img = resize.Resize(uint(float64(b.Dx())*scale+0.5), uint(float64(b.Dy())*scale+0.5), img, resize.Lanczos3)
+		b = img.Bounds()
+	}
+	x0 := b.Min.X + (b.Dx()-width)/2
+	y0 := b.Min.Y + (b.Dy()-height)/2
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(dst, dst.Bounds(), img, image.Pt(x0, y0), draw.Src)
+	return dst
+}
+
+// extraResolutionDirs returns the pieces directories of a puzzle's extra
+// tile sizes, such as pieces_128, which hold a copy of every tile.
+func extraResolutionDirs(manifest *Manifest) []string {
+	var dirs []string
+	for _, dir := range manifest.Resolutions {
+		if dir != "pieces" {
+			dirs = append(dirs, dir)
+		}
+	}
+	slices.Sort(dirs)
+	return dirs
+}
+
+// writeResolutionTiles writes tile, the new full-size image of file, to
+// every extra resolution of the puzzle, scaled to the dimensions of the
+// copy it replaces there.
+func (st *store) writeResolutionTiles(folder string, manifest *Manifest, file string, tile image.Image, format string) error {
+	tileSize := manifest.effectiveTileSize()
+	b := tile.Bounds()
+	for sizeStr, dir := range manifest.Resolutions {
+		if dir == "pieces" {
+			continue
+		}
+		size, err := strconv.Atoi(sizeStr)
+		if err != nil {
+			continue
+		}
+		path := filepath.Join(st.puzzlePath(folder), dir, file)
+		width, height := max(b.Dx()*size/tileSize, 1), max(b.Dy()*size/tileSize, 1)
+		if existing, err := os.Open(path); err == nil {
+			if cfg, _, err := image.DecodeConfig(existing); err == nil {
+				width, height = cfg.Width, cfg.Height
+			}
+			existing.Close()
+		}
+
+		var buf bytes.Buffer
+		if err := encodeTile(&buf, resize.Resize(uint(width), uint(height), tile, resize.Lanczos3), format); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func replacePieceHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseMultipartForm(10 << 20); err != nil {
+		http.Error(w, "Error parsing multipart form: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	folder := r.FormValue("folder")
+	if !validFolderName(folder) {
+		http.Error(w, "Invalid folder name", http.StatusBadRequest)
+		return
+	}
+	if !requirePuzzleVisible(w, r, folder) {
+		return
+	}
+	row, errRow := strconv.Atoi(r.FormValue("row"))
+	col, errCol := strconv.Atoi(r.FormValue("col"))
+	if errRow != nil || errCol != nil {
+		http.Error(w, "Invalid row or col", http.StatusBadRequest)
+		return
+	}
+
+	st := storeFor(r)
+	manifest, err := st.loadManifest(folder)
+	if err != nil {
+		http.Error(w, "Error reading manifest.json: "+err.Error(), http.StatusNotFound)
+		return
+	}
+	tileName, ok := manifest.Solution[fmt.Sprintf("%d,%d", row, col)]
+	if !ok {
+		http.Error(w, "No tile at that position", http.StatusNotFound)
+		return
+	}
+	tilePath := filepath.Join(st.puzzlePath(folder), "pieces", tileName)
+
+	file, _, err := r.FormFile("image")
+	if err != nil {
+		http.Error(w, "Error retrieving the file: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		http.Error(w, "Error decoding image: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Tiles on the right and bottom edges can be smaller than the tile
+	// size, so match the dimensions of the tile being replaced.
+	width, height := manifest.effectiveTileSize(), manifest.effectiveTileSize()
+	if existing, err := os.Open(tilePath); err == nil {
+		if cfg, _, err := image.DecodeConfig(existing); err == nil {
+			width, height = cfg.Width, cfg.Height
+		}
+		existing.Close()
+	}
+
+	tile := cropToFill(img, width, height)
+	var buf bytes.Buffer
+	if err := encodeTile(&buf, tile, manifest.TileFormat); err != nil {
+		http.Error(w, "Error encoding tile: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := os.WriteFile(tilePath, buf.Bytes(), 0644); err != nil {
+		http.Error(w, "Error writing tile file: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := st.recordPieceRewrite(folder, tileName, int64(buf.Len()), tile); err != nil {
+		log.Printf("Failed to record size of %s/%s: %v", folder, tileName, err)
+	}
+	st.invalidateExportCache(folder)
+	if err := st.writeResolutionTiles(folder, manifest, tileName, tile, manifest.TileFormat); err != nil {
+		http.Error(w, "Error writing tile file: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	sum := md5.Sum(buf.Bytes())
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"status": "ok",
+		"file":   tileName,
+		"md5":    hex.EncodeToString(sum[:]),
+		"_links": buildLinks(folder, r),
+	})
+}
+
+// swapPieceStats exchanges the recorded sizes and entropies of two tiles
+// whose images have been swapped.
+func swapPieceStats(manifest *Manifest, fileA, fileB string) {
+	var a, b *PieceInfo
+	for i := range manifest.Pieces {
+		switch manifest.Pieces[i].File {
+		case fileA:
+			a = &manifest.Pieces[i]
+		case fileB:
+			b = &manifest.Pieces[i]
+		}
+	}
+	if a != nil && b != nil {
+		a.SizeBytes, b.SizeBytes = b.SizeBytes, a.SizeBytes
+	}
+	if e := manifest.PieceEntropies; e != nil {
+		e[fileA], e[fileB] = e[fileB], e[fileA]
+	}
+}
+
+type SwapPiecesRequest struct {
+	Folder string `json:"folder"`
+	FileA  string `json:"fileA"`
+	FileB  string `json:"fileB"`
+}
+
+// swapPiecesHandler exchanges the images of two tiles and swaps their
+// solution positions so the puzzle remains solvable.
+func swapPiecesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req SwapPiecesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !validFolderName(req.Folder) {
+		http.Error(w, "Invalid folder name", http.StatusBadRequest)
+		return
+	}
+	if !requirePuzzleVisible(w, r, req.Folder) {
+		return
+	}
+	if req.FileA == req.FileB {
+		http.Error(w, "fileA and fileB must differ", http.StatusBadRequest)
+		return
+	}
+
+	st := storeFor(r)
+	manifestMutex.Lock()
+	defer manifestMutex.Unlock()
+
+	manifest, err := st.loadManifest(req.Folder)
+	if err != nil {
+		http.Error(w, "Error reading manifest.json: "+err.Error(), http.StatusNotFound)
+		return
+	}
+	var posA, posB string
+	for pos, file := range manifest.Solution {
+		switch file {
+		case req.FileA:
+			posA = pos
+		case req.FileB:
+			posB = pos
+		}
+	}
+	if posA == "" || posB == "" {
+		http.Error(w, "fileA and fileB must both be pieces of the puzzle", http.StatusNotFound)
+		return
+	}
+
+	// Swap the files in every resolution, swapping back the ones already
+	// done if one fails
+	dirs := append([]string{"pieces"}, extraResolutionDirs(manifest)...)
+	for i, dir := range dirs {
+		if err := swapTileFiles(filepath.Join(st.puzzlePath(req.Folder), dir), req.FileA, req.FileB); err != nil {
+			for _, done := range dirs[:i] {
+				swapTileFiles(filepath.Join(st.puzzlePath(req.Folder), done), req.FileA, req.FileB)
+			}
+			http.Error(w, "Error swapping tile files: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// The image that belonged at posA is now stored as fileB and vice versa
+	manifest.Solution[posA] = req.FileB
+	manifest.Solution[posB] = req.FileA
+	swapPieceStats(manifest, req.FileA, req.FileB)
+	if manifest.Neighbors != nil {
+		manifest.Neighbors = buildNeighbors(manifest.Solution)
+	}
+	if err := st.writeManifest(req.Folder, manifest); err != nil {
+		http.Error(w, "Error writing manifest.json: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	st.invalidateExportCache(req.Folder)
+
+	// Keep the solver's top-left tile in imageIndex.json in step
+	if topLeft := manifest.Solution["0,0"]; posA == "0,0" || posB == "0,0" {
+		if _, err := st.updateImageEntry(req.Folder, func(entry *ImageEntry) { entry.Tl = topLeft }); err != nil {
+			log.Printf("Failed to update top-left tile of %s: %v", req.Folder, err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"status": "ok",
+		"solution": map[string]string{
+			posA: manifest.Solution[posA],
+			posB: manifest.Solution[posB],
+		},
+		"_links": buildLinks(req.Folder, r),
+	})
+}
+
+// renameTile is os.Rename, swapped out by tests to make a rename fail.
+var renameTile = os.Rename
+
+// swapTileFiles exchanges the files fileA and fileB in dir. If a rename
+// fails, the ones already done are undone so both files keep their images.
+func swapTileFiles(dir, fileA, fileB string) error {
+	pathA := filepath.Join(dir, fileA)
+	pathB := filepath.Join(dir, fileB)
+	tmpPath := filepath.Join(dir, ".swap_"+fileA)
+	if err := renameTile(pathA, tmpPath); err != nil {
+		return err
+	}
+	if err := renameTile(pathB, pathA); err != nil {
+		renameTile(tmpPath, pathA)
+		return err
+	}
+	if err := renameTile(tmpPath, pathB); err != nil {
+		// A holds B's image and B is missing: move both back
+		renameTile(pathA, pathB)
+		renameTile(tmpPath, pathA)
+		return err
+	}
+	return nil
+}
+
+// rotateImage rotates img clockwise by 90, 180 or 270 degrees.
+func rotateImage(img image.Image, degrees int) *image.RGBA {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	var dst *image.RGBA
+	if degrees == 180 {
+		dst = image.NewRGBA(image.Rect(0, 0, w, h))
+	} else {
+		dst = image.NewRGBA(image.Rect(0, 0, h, w))
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := img.At(b.Min.X+x, b.Min.Y+y)
+			switch degrees {
+			case 90:
+				dst.Set(h-1-y, x, c)
+			case 180:
+				dst.Set(w-1-x, h-1-y, c)
+			case 270:
+				dst.Set(y, w-1-x, c)
+			}
+		}
+	}
+	return dst
+}
+
+// rotateTileFile rotates the tile stored at path in place.
+func rotateTileFile(path string, degrees int) error {
+	tileFile, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	img, format, err := image.Decode(tileFile)
+	tileFile.Close()
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := encodeTile(&buf, rotateImage(img, degrees), format); err != nil {
+		return err
+	}
+	return os.WriteFile(path, buf.Bytes(), 0644)
+}
+
+type RotatePieceRequest struct {
+	Folder  string `json:"folder"`
+	File    string `json:"file"`
+	Degrees int    `json:"degrees"`
+}
+
+func rotatePieceHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req RotatePieceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !validFolderName(req.Folder) || !validFolderName(req.File) {
+		http.Error(w, "Invalid folder or file name", http.StatusBadRequest)
+		return
+	}
+	if !requirePuzzleVisible(w, r, req.Folder) {
+		return
+	}
+	if req.Degrees != 90 && req.Degrees != 180 && req.Degrees != 270 {
+		http.Error(w, "degrees must be 90, 180 or 270", http.StatusBadRequest)
+		return
+	}
+
+	st := storeFor(r)
+	tilePath := filepath.Join(st.puzzlePath(req.Folder), "pieces", req.File)
+	tileFile, err := os.Open(tilePath)
+	if err != nil {
+		http.Error(w, "Tile not found", http.StatusNotFound)
+		return
+	}
+	img, format, err := image.Decode(tileFile)
+	tileFile.Close()
+	if err != nil {
+		http.Error(w, "Error decoding tile: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rotated := rotateImage(img, req.Degrees)
+	var buf bytes.Buffer
+	if err := encodeTile(&buf, rotated, format); err != nil {
+		http.Error(w, "Error encoding tile: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := os.WriteFile(tilePath, buf.Bytes(), 0644); err != nil {
+		http.Error(w, "Error writing tile file: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := st.recordPieceRewrite(req.Folder, req.File, int64(buf.Len()), nil); err != nil {
+		log.Printf("Failed to record size of %s/%s: %v", req.Folder, req.File, err)
+	}
+	st.invalidateExportCache(req.Folder)
+	if manifest, err := st.loadManifest(req.Folder); err == nil {
+		for _, dir := range extraResolutionDirs(manifest) {
+			if err := rotateTileFile(filepath.Join(st.puzzlePath(req.Folder), dir, req.File), req.Degrees); err != nil {
+				http.Error(w, "Error rotating tile file: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"status": "ok",
+		"file":   req.File,
+		"width":  rotated.Bounds().Dx(),
+		"height": rotated.Bounds().Dy(),
+		"_links": buildLinks(req.Folder, r),
+	})
+}
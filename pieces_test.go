@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// uploadWithResolutions uploads a 2x2 puzzle of 64px tiles that also keeps
+// 32px copies of them in pieces_32/.
+func uploadWithResolutions(t *testing.T) (*http.ServeMux, *store, *Manifest) {
+	t.Helper()
+	mux, st := newTestMux(t)
+	mustUpload(t, mux, encodePNG(t, testImage(128, 128, 1)), map[string]string{
+		"name": "foo", "columns": "2", "tileSize": "64", "extraResolutions": "32",
+	})
+	manifest, err := st.loadManifest("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Resolutions["32"] != "pieces_32" {
+		t.Fatalf("resolutions %v, want 32 in pieces_32", manifest.Resolutions)
+	}
+	return mux, st, manifest
+}
+
+func TestSwapPiecesEveryResolution(t *testing.T) {
+	mux, st, manifest := uploadWithResolutions(t)
+	fileA, fileB := manifest.Solution["0,0"], manifest.Solution["1,1"]
+	before := readPiecesDir(t, st, "foo", "pieces_32")
+
+	rec := postJSON(t, mux, "/swapPieces", SwapPiecesRequest{Folder: "foo", FileA: fileA, FileB: fileB})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	after := readPiecesDir(t, st, "foo", "pieces_32")
+	if len(after) != len(before) {
+		t.Errorf("pieces_32/ holds %d files, want %d", len(after), len(before))
+	}
+	if !bytes.Equal(after[fileA], before[fileB]) || !bytes.Equal(after[fileB], before[fileA]) {
+		t.Error("pieces_32/ tiles were not swapped along with pieces/")
+	}
+}
+
+func TestRotatePieceEveryResolution(t *testing.T) {
+	mux, st, manifest := uploadWithResolutions(t)
+	file := manifest.Solution["0,1"]
+	small, _, err := image.Decode(bytes.NewReader(readPiecesDir(t, st, "foo", "pieces_32")[file]))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := postJSON(t, mux, "/rotatePiece", RotatePieceRequest{Folder: "foo", File: file, Degrees: 90})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	rotated, _, err := image.Decode(bytes.NewReader(readPiecesDir(t, st, "foo", "pieces_32")[file]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := rotateImage(small, 90)
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 32; x++ {
+			r1, g1, b1, a1 := rotated.At(x, y).RGBA()
+			r2, g2, b2, a2 := want.At(x, y).RGBA()
+			if r1 != r2 || g1 != g2 || b1 != b2 || a1 != a2 {
+				t.Fatalf("pieces_32/%s at %d,%d is %v, want %v", file, x, y, rotated.At(x, y), want.At(x, y))
+			}
+		}
+	}
+}
+
+func TestReplacePieceEveryResolution(t *testing.T) {
+	mux, st, manifest := uploadWithResolutions(t)
+	file := manifest.Solution["1,0"]
+	before := readPiecesDir(t, st, "foo", "pieces_32")[file]
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("folder", "foo")
+	mw.WriteField("row", "1")
+	mw.WriteField("col", "0")
+	fw, err := mw.CreateFormFile("image", "tile.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write(encodePNG(t, testImage(64, 64, 7)))
+	mw.Close()
+	req := httptest.NewRequest(http.MethodPost, "/replacePiece", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+
+	after := readPiecesDir(t, st, "foo", "pieces_32")[file]
+	if bytes.Equal(after, before) {
+		t.Fatalf("pieces_32/%s was not replaced", file)
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(after))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Width != 32 || cfg.Height != 32 {
+		t.Errorf("pieces_32/%s is %dx%d, want 32x32", file, cfg.Width, cfg.Height)
+	}
+}
+
+func TestEqualizeHistogramEveryResolution(t *testing.T) {
+	mux, st, _ := uploadWithResolutions(t)
+	before := readPiecesDir(t, st, "foo", "pieces_32")
+
+	rec := postJSON(t, mux, "/equalizeHistogram", EqualizeHistogramRequest{Folder: "foo"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	after := readPiecesDir(t, st, "foo", "pieces_32")
+	for file, data := range before {
+		if bytes.Equal(after[file], data) {
+			t.Errorf("pieces_32/%s was not equalized", file)
+		}
+	}
+}
+
+func TestSwapTileFilesRollsBack(t *testing.T) {
+	for fail := 1; fail <= 3; fail++ {
+		dir := t.TempDir()
+		for file, data := range map[string]string{"a.png": "A", "b.png": "B"} {
+			if err := os.WriteFile(filepath.Join(dir, file), []byte(data), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		calls := 0
+		setFlag(t, &renameTile, func(oldPath, newPath string) error {
+			if calls++; calls == fail {
+				return errors.New("rename failed")
+			}
+			return os.Rename(oldPath, newPath)
+		})
+
+		if err := swapTileFiles(dir, "a.png", "b.png"); err == nil {
+			t.Errorf("rename %d failing: swap succeeded", fail)
+		}
+		for file, want := range map[string]string{"a.png": "A", "b.png": "B"} {
+			if data, err := os.ReadFile(filepath.Join(dir, file)); err != nil || string(data) != want {
+				t.Errorf("rename %d failing: %s holds %q, %v; want %q", fail, file, data, err, want)
+			}
+		}
+		if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+			t.Errorf("rename %d failing: %d files left, want 2", fail, len(entries))
+		}
+	}
+}
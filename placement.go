@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+)
+
+// PlacedTile is a tile on an exported board. In JSON it is either just the
+// filename or an object that also transforms the tile:
+//
+//	"0,1": "image_0001.png"
+//	"0,2": {"file": "image_0002.png", "rotation": 90, "flipH": true}
+type PlacedTile struct {
+	File     string `json:"file"`
+	Rotation int    `json:"rotation,omitempty"` // clockwise degrees: 0, 90, 180 or 270
+	FlipH    bool   `json:"flipH,omitempty"`
+	FlipV    bool   `json:"flipV,omitempty"`
+}
+
+// placedTileJSON has the fields of PlacedTile without its JSON methods.
+type placedTileJSON PlacedTile
+
+func (p *PlacedTile) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		*p = PlacedTile{}
+		return json.Unmarshal(data, &p.File)
+	}
+	return json.Unmarshal(data, (*placedTileJSON)(p))
+}
+
+// MarshalJSON writes untransformed tiles as plain filenames, as older
+// clients expect.
+func (p PlacedTile) MarshalJSON() ([]byte, error) {
+	if p.Rotation == 0 && !p.FlipH && !p.FlipV {
+		return json.Marshal(p.File)
+	}
+	return json.Marshal(placedTileJSON(p))
+}
+
+// placementsFromSolution turns a manifest solution into untransformed
+// placements.
+func placementsFromSolution(solution map[string]string) map[string]PlacedTile {
+	placements := make(map[string]PlacedTile, len(solution))
+	for pos, file := range solution {
+		placements[pos] = PlacedTile{File: file}
+	}
+	return placements
+}
+
+func (p PlacedTile) validate() error {
+	switch p.Rotation {
+	case 0, 90, 180, 270:
+		return nil
+	}
+	return fmt.Errorf("invalid rotation %d of %s: must be 0, 90, 180 or 270", p.Rotation, p.File)
+}
+
+// transform applies the tile's flips and then its rotation to img.
+func (p PlacedTile) transform(img image.Image) image.Image {
+	if p.FlipH {
+		img = flipImage(img, false)
+	}
+	if p.FlipV {
+		img = flipImage(img, true)
+	}
+	if p.Rotation != 0 {
+		img = rotateImage(img, p.Rotation)
+	}
+	return img
+}
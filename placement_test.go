@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestPlacedTileTransform(t *testing.T) {
+	// Every pixel of a 3x2 tile has its own colour
+	const w, h = 3, 2
+	src := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			src.Set(x, y, color.RGBA{R: uint8(x * 80), G: uint8(y * 80), A: 255})
+		}
+	}
+
+	for _, rotation := range []int{0, 90, 180, 270} {
+		for _, flipH := range []bool{false, true} {
+			for _, flipV := range []bool{false, true} {
+				p := PlacedTile{File: "a.png", Rotation: rotation, FlipH: flipH, FlipV: flipV}
+				t.Run(fmt.Sprintf("rotation=%d,flipH=%v,flipV=%v", rotation, flipH, flipV), func(t *testing.T) {
+					got := p.transform(src)
+
+					// Move each source pixel: flips first, then quarter turns clockwise
+					want := map[image.Point]color.Color{}
+					dw, dh := w, h
+					for y := 0; y < h; y++ {
+						for x := 0; x < w; x++ {
+							px, py := x, y
+							if flipH {
+								px = w - 1 - px
+							}
+							if flipV {
+								py = h - 1 - py
+							}
+							dw, dh = w, h
+							for turns := rotation / 90; turns > 0; turns-- {
+								px, py = dh-1-py, px
+								dw, dh = dh, dw
+							}
+							want[image.Pt(px, py)] = src.At(x, y)
+						}
+					}
+
+					b := got.Bounds()
+					if b.Dx() != dw || b.Dy() != dh {
+						t.Fatalf("size %dx%d, want %dx%d", b.Dx(), b.Dy(), dw, dh)
+					}
+					for pt, c := range want {
+						if g := got.At(b.Min.X+pt.X, b.Min.Y+pt.Y); color.RGBAModel.Convert(g) != color.RGBAModel.Convert(c) {
+							t.Errorf("pixel %v is %v, want %v", pt, g, c)
+						}
+					}
+
+					// The transform survives the trip through an export payload
+					data, err := json.Marshal(p)
+					if err != nil {
+						t.Fatal(err)
+					}
+					var back PlacedTile
+					if err := json.Unmarshal(data, &back); err != nil || back != p {
+						t.Errorf("%s unmarshals to %+v, want %+v", data, back, p)
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestPlacedTileValidate(t *testing.T) {
+	for _, rotation := range []int{-90, 45, 360} {
+		if err := (PlacedTile{File: "a.png", Rotation: rotation}).validate(); err == nil {
+			t.Errorf("rotation %d accepted", rotation)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"plugin"
+)
+
+// loadPlugins opens every .so file in dir and calls its exported
+//
+//	func Register(mux *http.ServeMux)
+//
+// with the mux serving the API routes. Plugins must be built with
+// -buildmode=plugin against the same Go version and dependencies as the
+// server; one that fails to load is logged and skipped.
+func loadPlugins(dir string, mux *http.ServeMux) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		log.Printf("Cannot read plugin directory: %v", err)
+		return
+	}
+	if info.Mode().Perm()&0002 != 0 {
+		log.Printf("WARNING: plugin directory %s is world-writable; anyone can run code in this server", dir)
+	}
+
+	paths, _ := filepath.Glob(filepath.Join(dir, "*.so"))
+	for _, path := range paths {
+		p, err := plugin.Open(path)
+		if err != nil {
+			log.Printf("Failed to load plugin %s: %v", path, err)
+			continue
+		}
+		sym, err := p.Lookup("Register")
+		if err != nil {
+			log.Printf("Plugin %s has no Register function: %v", path, err)
+			continue
+		}
+		register, ok := sym.(func(*http.ServeMux))
+		if !ok {
+			log.Printf("Plugin %s: Register must be func(*http.ServeMux), got %T", path, sym)
+			continue
+		}
+		register(mux)
+		log.Printf("Loaded plugin %s", path)
+	}
+}
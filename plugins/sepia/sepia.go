@@ -0,0 +1,61 @@
+// Command sepia is an example TilePuzzler plugin adding a POST /sepia
+// endpoint that returns the uploaded image with a sepia tone as PNG.
+//
+// Build it next to the server and start the server with -pluginDir:
+//
+//	go build -buildmode=plugin -o plugins/sepia.so ./plugins/sepia
+//	./tilepuzzler -pluginDir plugins
+package main
+
+import (
+	"image"
+	"image/color"
+	_ "image/jpeg"
+	"image/png"
+	"net/http"
+)
+
+// Register is called by the server once the plugin is loaded.
+func Register(mux *http.ServeMux) {
+	mux.HandleFunc("/sepia", sepiaHandler)
+}
+
+func sepiaHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	img, _, err := image.Decode(http.MaxBytesReader(w, r.Body, 10<<20))
+	if err != nil {
+		http.Error(w, "Error decoding image: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	b := img.Bounds()
+	dst := image.NewNRGBA(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			r, g, bl := float64(c.R), float64(c.G), float64(c.B)
+			dst.SetNRGBA(x, y, color.NRGBA{
+				R: clamp(0.393*r + 0.769*g + 0.189*bl),
+				G: clamp(0.349*r + 0.686*g + 0.168*bl),
+				B: clamp(0.272*r + 0.534*g + 0.131*bl),
+				A: c.A,
+			})
+		}
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	if err := png.Encode(w, dst); err != nil {
+		http.Error(w, "Failed to encode PNG: "+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func clamp(v float64) uint8 {
+	return uint8(min(v, 255))
+}
+
+// main is required by -buildmode=plugin but never runs.
+func main() {}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+)
+
+// prefetchOrder returns the grid positions ("row,col") in the order they
+// should be fetched for the given strategy.
+func prefetchOrder(rows, cols int, strategy string, seed int64) []string {
+	var order []string
+	switch strategy {
+	case "center-out":
+		// Walk a square spiral out from the centre cell, keeping the cells
+		// that fall inside the grid, until every cell has been visited.
+		r, c := (rows-1)/2, (cols-1)/2
+		dirs := [4][2]int{{0, 1}, {1, 0}, {0, -1}, {-1, 0}}
+		visit := func(r, c int) {
+			if r >= 0 && r < rows && c >= 0 && c < cols {
+				order = append(order, fmt.Sprintf("%d,%d", r, c))
+			}
+		}
+		visit(r, c)
+		for step, d := 1, 0; len(order) < rows*cols; step++ {
+			// Each step length is used for two sides of the spiral.
+			for side := 0; side < 2; side++ {
+				for i := 0; i < step; i++ {
+					r += dirs[d][0]
+					c += dirs[d][1]
+					visit(r, c)
+				}
+				d = (d + 1) % 4
+			}
+		}
+	default:
+		for r := 0; r < rows; r++ {
+			for c := 0; c < cols; c++ {
+				order = append(order, fmt.Sprintf("%d,%d", r, c))
+			}
+		}
+		if strategy == "random" {
+			rng := rand.New(rand.NewSource(seed))
+			rng.Shuffle(len(order), func(i, j int) { order[i], order[j] = order[j], order[i] })
+		}
+	}
+	return order
+}
+
+func prefetchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	folder := r.URL.Query().Get("folder")
+	if !validFolderName(folder) {
+		http.Error(w, "Invalid folder name", http.StatusBadRequest)
+		return
+	}
+
+	strategy := r.URL.Query().Get("strategy")
+	switch strategy {
+	case "":
+		strategy = "row-by-row"
+	case "row-by-row", "center-out", "random":
+	default:
+		http.Error(w, "Invalid strategy: must be center-out, row-by-row or random", http.StatusBadRequest)
+		return
+	}
+
+	var seed int64
+	if seedStr := r.URL.Query().Get("seed"); seedStr != "" {
+		var err error
+		seed, err = strconv.ParseInt(seedStr, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid seed", http.StatusBadRequest)
+			return
+		}
+	}
+
+	st := storeFor(r)
+	manifest, err := st.loadManifest(folder)
+	if err != nil {
+		http.Error(w, "Error reading manifest.json: "+err.Error(), http.StatusNotFound)
+		return
+	}
+
+	rows, cols := manifest.gridSize()
+	urls := []string{}
+	for _, pos := range prefetchOrder(rows, cols, strategy, seed) {
+		if file, ok := manifest.Solution[pos]; ok {
+			urls = append(urls, st.imagesURL(folder+"/pieces/"+file))
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(urls)
+}
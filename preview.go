@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/nfnt/resize"
+)
+
+const (
+	minPreviewScale = 0.05
+	maxPreviewScale = 0.5
+)
+
+// assemblyPreviewHandler assembles a puzzle's solution from downscaled
+// tiles, as a cheap alternative to /exportPuzzle for checking the tiles. A
+// scale above maxPreviewScale is capped rather than rejected.
+func assemblyPreviewHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	folder := r.URL.Query().Get("folder")
+	if !validFolderName(folder) {
+		http.Error(w, "Invalid folder name", http.StatusBadRequest)
+		return
+	}
+	scale, err := strconv.ParseFloat(formValueOr(r, "scale", "0.1"), 64)
+	if err != nil || math.IsNaN(scale) || scale < minPreviewScale {
+		http.Error(w, fmt.Sprintf("Invalid scale: must be at least %g; larger values are capped at %g", minPreviewScale, maxPreviewScale), http.StatusBadRequest)
+		return
+	}
+	scale = min(scale, maxPreviewScale)
+
+	st := storeFor(r)
+	manifest, err := st.loadManifest(folder)
+	if err != nil {
+		http.Error(w, "Error reading manifest.json: "+err.Error(), http.StatusNotFound)
+		return
+	}
+
+	tileSize := manifest.effectiveTileSize()
+	scaled := func(v int) int { return int(math.Round(float64(v) * scale)) }
+	rows, cols := manifest.gridSize()
+	dst := image.NewRGBA(image.Rect(0, 0, scaled(manifest.columnX(cols)), scaled(rows*tileSize)))
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+
+	piecesPath := filepath.Join(st.puzzlePath(folder), "pieces")
+	var covered image.Rectangle
+	for pos, file := range manifest.Solution {
+		var row, col int
+		fmt.Sscanf(pos, "%d,%d", &row, &col)
+
+		tileFile, err := os.Open(filepath.Join(piecesPath, file))
+		if err != nil {
+			log.Printf("Failed to open tile %s: %v", file, err)
+			continue
+		}
+		tile, _, err := image.Decode(tileFile)
+		tileFile.Close()
+		if err != nil {
+			log.Printf("Failed to decode tile %s: %v", file, err)
+			continue
+		}
+
+		// Scale the tile's corners rather than its size so that neighbours
+		// meet without gaps from rounding
+		x0, y0 := manifest.columnX(col), row*tileSize
+		rect := image.Rect(scaled(x0), scaled(y0), scaled(x0+tile.Bounds().Dx()), scaled(y0+tile.Bounds().Dy()))
+		if rect.Empty() {
+			continue
+		}
+		small := resize.Resize(uint(rect.Dx()), uint(rect.Dy()), tile, resize.Lanczos3)
+		draw.Draw(dst, rect, small, small.Bounds().Min, draw.Over)
+		covered = covered.Union(rect)
+	}
+
+	// Trim the unused part of short last rows and columns
+	var preview image.Image = dst
+	if !covered.Empty() {
+		preview = dst.SubImage(covered.Intersect(dst.Bounds()))
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	if err := jpeg.Encode(w, preview, &jpeg.Options{Quality: 80}); err != nil {
+		log.Printf("Failed to encode preview of %s: %v", folder, err)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestAssemblyPreviewScale(t *testing.T) {
+	mux, _ := newTestMux(t)
+	mustUpload(t, mux, encodePNG(t, testImage(128, 128, 1)), map[string]string{
+		"name": "foo", "columns": "2", "tileSize": "64",
+	})
+
+	tests := []struct {
+		scale  string
+		status int
+		width  int
+	}{
+		{"0.25", http.StatusOK, 32},
+		{"0.5", http.StatusOK, 64},
+		{"2", http.StatusOK, 64}, // capped
+		{"0.01", http.StatusBadRequest, 0},
+		{"NaN", http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		rec := get(mux, "/assemblyPreview?folder=foo&scale="+tt.scale)
+		if rec.Code != tt.status {
+			t.Errorf("scale %s: status %d, want %d: %s", tt.scale, rec.Code, tt.status, rec.Body)
+			continue
+		}
+		if tt.status != http.StatusOK {
+			if !strings.Contains(rec.Body.String(), "capped at 0.5") {
+				t.Errorf("scale %s: error %q does not say large values are capped", tt.scale, rec.Body)
+			}
+			continue
+		}
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(rec.Body.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.Width != tt.width {
+			t.Errorf("scale %s: preview %d wide, want %d", tt.scale, cfg.Width, tt.width)
+		}
+	}
+}
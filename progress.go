@@ -0,0 +1,154 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// uploadJobTTL is how long a job stays available after it was created.
+const uploadJobTTL = 10 * time.Minute
+
+// JobProgress is one event of the /uploadProgress stream.
+type JobProgress struct {
+	Done   int    `json:"done"`
+	Total  int    `json:"total"`
+	Status string `json:"status,omitempty"` // "complete" or "error" on the final event
+	Folder string `json:"folder,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// uploadJob is a puzzle being sliced in the background. progress holds at
+// most the latest update and is closed when the job ends, after which
+// result is set.
+type uploadJob struct {
+	progress chan JobProgress
+	last     JobProgress // only touched by the producer
+	result   JobProgress
+}
+
+var (
+	uploadJobs      = map[string]*uploadJob{}
+	uploadJobsMutex sync.Mutex
+)
+
+// newUploadJob registers a new job under a random ID.
+func newUploadJob() (string, *uploadJob) {
+	var b [16]byte
+	rand.Read(b[:])
+	id := hex.EncodeToString(b[:])
+	job := &uploadJob{progress: make(chan JobProgress, 1)}
+
+	uploadJobsMutex.Lock()
+	uploadJobs[id] = job
+	uploadJobsMutex.Unlock()
+	time.AfterFunc(uploadJobTTL, func() {
+		uploadJobsMutex.Lock()
+		delete(uploadJobs, id)
+		uploadJobsMutex.Unlock()
+	})
+	return id, job
+}
+
+// report publishes the job's progress, replacing an update the client has
+// not read yet so a slow client never stalls the slicing.
+func (job *uploadJob) report(done, total int) {
+	job.last = JobProgress{Done: done, Total: total}
+	select {
+	case <-job.progress:
+	default:
+	}
+	job.progress <- job.last
+}
+
+// finish records the outcome of the job and closes its progress channel.
+func (job *uploadJob) finish(folder string, err error) {
+	job.result = JobProgress{Done: job.last.Done, Total: job.last.Total, Status: "complete", Folder: folder}
+	if err != nil {
+		job.result.Status = "error"
+		job.result.Error = err.Error()
+	}
+	close(job.progress)
+}
+
+// uploadProgressHandler streams the progress of an async upload as
+// Server-Sent Events and closes the response once the job has ended.
+func uploadProgressHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	uploadJobsMutex.Lock()
+	job, ok := uploadJobs[r.URL.Query().Get("jobId")]
+	uploadJobsMutex.Unlock()
+	if !ok {
+		http.Error(w, "Unknown jobId", http.StatusNotFound)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	send := func(p JobProgress) {
+		data, _ := json.Marshal(p)
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		flusher.Flush()
+	}
+	for {
+		select {
+		case p, open := <-job.progress:
+			if !open {
+				send(job.result)
+				return
+			}
+			send(p)
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// generationStatusHandler reports whether a puzzle is ready. Its directory
+// is created when the upload starts but it only joins imageIndex.json once
+// slicing has finished, so a directory without an index entry is still
+// being generated.
+func generationStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	folder := r.URL.Query().Get("folder")
+	if !validFolderName(folder) {
+		http.Error(w, "Invalid folder name", http.StatusBadRequest)
+		return
+	}
+
+	st := storeFor(r)
+	status := map[string]any{"status": "ready"}
+	if _, err := os.Stat(st.puzzlePath(folder)); err != nil {
+		status["status"] = "notStarted"
+	} else if _, ok, err := st.findImageEntry(folder); err != nil {
+		http.Error(w, "Error reading imageIndex.json: "+err.Error(), http.StatusInternalServerError)
+		return
+	} else if !ok {
+		pieces, _ := os.ReadDir(filepath.Join(st.puzzlePath(folder), "pieces"))
+		status["status"] = "generating"
+		status["piecesOnDisk"] = len(pieces)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// qualitySampleSize is the side of the thumbnails compared by
+// checkTileQuality.
+const qualitySampleSize = 16
+
+type CheckTileQualityRequest struct {
+	Folder    string  `json:"folder"`
+	Threshold float64 `json:"threshold"` // tiles scoring below it are suspect; 0 means 0.5
+}
+
+type TileQuality struct {
+	File    string  `json:"file"`
+	SSIM    float64 `json:"ssim"`
+	Suspect bool    `json:"suspect"`
+	Error   string  `json:"error,omitempty"`
+}
+
+// grayscaleSample downscales img to qualitySampleSize pixels square and
+// returns its luma values.
+func grayscaleSample(img image.Image) []float64 {
+	small := resizeImage(img, qualitySampleSize, qualitySampleSize)
+	b := small.Bounds()
+	values := make([]float64, 0, b.Dx()*b.Dy())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			values = append(values, float64(color.GrayModel.Convert(small.At(x, y)).(color.Gray).Y))
+		}
+	}
+	return values
+}
+
+// ssim computes the structural similarity of two equally sized samples over
+// a single window covering all of them, rather than the usual sliding
+// windows. 1 means identical.
+func ssim(a, b []float64) float64 {
+	const (
+		c1 = (0.01 * 255) * (0.01 * 255)
+		c2 = (0.03 * 255) * (0.03 * 255)
+	)
+	n := float64(len(a))
+	var meanA, meanB float64
+	for i := range a {
+		meanA += a[i]
+		meanB += b[i]
+	}
+	meanA /= n
+	meanB /= n
+	var varA, varB, cov float64
+	for i := range a {
+		da, db := a[i]-meanA, b[i]-meanB
+		varA += da * da
+		varB += db * db
+		cov += da * db
+	}
+	varA /= n - 1
+	varB /= n - 1
+	cov /= n - 1
+	return ((2*meanA*meanB + c1) * (2*cov + c2)) / ((meanA*meanA + meanB*meanB + c1) * (varA + varB + c2))
+}
+
+// checkTileQualityHandler compares every tile of a puzzle with its region
+// of the index image and flags the tiles that no longer look like it, e.g.
+// because the file is corrupt or was replaced.
+func checkTileQualityHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req CheckTileQualityRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !validFolderName(req.Folder) {
+		http.Error(w, "Invalid folder name", http.StatusBadRequest)
+		return
+	}
+	if !requirePuzzleVisible(w, r, req.Folder) {
+		return
+	}
+	if req.Threshold < 0 || req.Threshold > 1 {
+		http.Error(w, "Invalid threshold: must be between 0 and 1", http.StatusBadRequest)
+		return
+	}
+	threshold := req.Threshold
+	if threshold == 0 {
+		threshold = 0.5
+	}
+
+	st := storeFor(r)
+	manifest, err := st.loadManifest(req.Folder)
+	if err != nil {
+		http.Error(w, "Error reading manifest.json: "+err.Error(), http.StatusNotFound)
+		return
+	}
+	indexImg, err := st.loadIndexImage(req.Folder, manifest)
+	if err != nil {
+		http.Error(w, "Error reading index image: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	positions := make(map[string]image.Point, len(manifest.Solution))
+	for pos, file := range manifest.Solution {
+		var row, col int
+		if _, err := fmt.Sscanf(pos, "%d,%d", &row, &col); err == nil {
+			positions[file] = image.Pt(col, row)
+		}
+	}
+
+	tileSize := manifest.effectiveTileSize()
+	piecesPath := filepath.Join(st.puzzlePath(req.Folder), "pieces")
+	results := []TileQuality{}
+	for _, piece := range manifest.Pieces {
+		result := TileQuality{File: piece.File, Suspect: true}
+		if err := func() error {
+			pos, ok := positions[piece.File]
+			if !ok {
+				return fmt.Errorf("not in the solution")
+			}
+			f, err := os.Open(filepath.Join(piecesPath, piece.File))
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			tile, _, err := image.Decode(f)
+			if err != nil {
+				return err
+			}
+
+			x0, y0 := manifest.columnX(pos.X), pos.Y*tileSize
+			region := image.Rect(x0, y0, x0+tile.Bounds().Dx(), y0+tile.Bounds().Dy()).Intersect(indexImg.Bounds())
+			if region.Empty() {
+				return fmt.Errorf("outside the index image")
+			}
+			sub, ok := indexImg.(interface {
+				SubImage(image.Rectangle) image.Image
+			})
+			if !ok {
+				return fmt.Errorf("index image cannot be cropped")
+			}
+			result.SSIM = math.Round(ssim(grayscaleSample(tile), grayscaleSample(sub.SubImage(region)))*1000) / 1000
+			result.Suspect = result.SSIM < threshold
+			return nil
+		}(); err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
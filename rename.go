@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+type RenameRequest struct {
+	Folder  string `json:"folder"`
+	NewName string `json:"newName"`
+}
+
+// copyDir copies the tree at src to dst, which must not exist yet.
+func copyDir(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if d.IsDir() {
+			return os.Mkdir(target, 0755)
+		}
+		in, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, in); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	})
+}
+
+// moveDir renames src to dst, copying and then deleting it when they are on
+// different filesystems.
+func moveDir(src, dst string) error {
+	err := os.Rename(src, dst)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	if err := copyDir(src, dst); err != nil {
+		os.RemoveAll(dst)
+		return err
+	}
+	return os.RemoveAll(src)
+}
+
+// renamePuzzle gives a puzzle a new display name and moves it to the folder
+// derived from that name. It returns the new folder.
+//
+// Requests for /images/<folder>/... that opened a file before the move keep
+// reading it, as open files survive a rename on Unix and only the
+// copy-then-delete fallback can cut them short; requests arriving after it
+// get a 404. Neither panics, and locking every image request on the folder
+// lock is not worth it for an admin operation.
+func (st *store) renamePuzzle(folder, newName string) (string, error) {
+	newFolder := toSnakeCase(newName)
+	if !validFolderName(newFolder) {
+		return "", &statusError{http.StatusBadRequest, "Invalid newName"}
+	}
+
+	// Take both folder locks in a fixed order so that two opposite renames
+	// cannot deadlock
+	first, second := folder, newFolder
+	if second < first {
+		first, second = second, first
+	}
+	defer st.lockFolder(first)()
+	if second != first {
+		defer st.lockFolder(second)()
+	}
+	manifestMutex.Lock()
+	defer manifestMutex.Unlock()
+	imageIndexMutex.Lock()
+	defer imageIndexMutex.Unlock()
+
+	imageIndex, err := st.loadImageIndex()
+	if err != nil {
+		return "", &statusError{http.StatusInternalServerError, "Error reading imageIndex.json: " + err.Error()}
+	}
+	i := findEntryIndex(imageIndex, folder, false)
+	if i < 0 {
+		return "", &statusError{http.StatusNotFound, "Puzzle not found"}
+	}
+
+	if newFolder != folder {
+		newPath := st.puzzlePath(newFolder)
+		_, errLive := os.Stat(newPath)
+		_, errTrash := os.Stat(st.trashPath(newFolder))
+		taken := findEntryIndex(imageIndex, newFolder, false) >= 0 || findEntryIndex(imageIndex, newFolder, true) >= 0
+		if taken || !errors.Is(errLive, os.ErrNotExist) || !errors.Is(errTrash, os.ErrNotExist) {
+			return "", &statusError{http.StatusConflict, "A puzzle named " + newFolder + " already exists"}
+		}
+		if err := os.MkdirAll(filepath.Dir(newPath), 0755); err != nil {
+			return "", &statusError{http.StatusInternalServerError, "Error creating puzzle directory: " + err.Error()}
+		}
+		if err := moveDir(st.puzzlePath(folder), newPath); err != nil {
+			return "", &statusError{http.StatusInternalServerError, "Error moving puzzle: " + err.Error()}
+		}
+		st.invalidateExportCache(folder)
+	}
+
+	// Manifests only hold paths relative to the puzzle folder, so only the
+	// index entry needs rewriting
+	entry := &imageIndex.Images[i]
+	entry.Name = newName
+	entry.Folder = newFolder
+	if rest, ok := strings.CutPrefix(entry.ThumbPath, folder+"/"); ok {
+		entry.ThumbPath = newFolder + "/" + rest
+	}
+	if err := st.saveImageIndex(imageIndex); err != nil {
+		return "", &statusError{http.StatusInternalServerError, "Error writing imageIndex.json: " + err.Error()}
+	}
+	return newFolder, nil
+}
+
+func renamePuzzleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	var req RenameRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !validFolderName(req.Folder) {
+		http.Error(w, "Invalid folder name", http.StatusBadRequest)
+		return
+	}
+	if !requirePuzzleVisible(w, r, req.Folder) {
+		return
+	}
+	if strings.TrimSpace(req.NewName) == "" {
+		http.Error(w, "newName required", http.StatusBadRequest)
+		return
+	}
+
+	newFolder, err := storeFor(r).renamePuzzle(req.Folder, req.NewName)
+	if err != nil {
+		writeStatusError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"status": "ok",
+		"folder": newFolder,
+		"name":   req.NewName,
+		"_links": buildLinks(newFolder, r),
+	})
+}
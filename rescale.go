@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+)
+
+type RescalePuzzleRequest struct {
+	Folder      string `json:"folder"`
+	NewTileSize int    `json:"newTileSize"`
+}
+
+// rescalePuzzleHandler slices a puzzle's index image again with a different
+// tile size but the same number of columns. The result is a new puzzle in
+// <folder>_<newTileSize>; the original is left untouched.
+func rescalePuzzleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req RescalePuzzleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !validFolderName(req.Folder) {
+		http.Error(w, "Invalid folder name", http.StatusBadRequest)
+		return
+	}
+	if !requirePuzzleVisible(w, r, req.Folder) {
+		return
+	}
+	if req.NewTileSize < 16 || req.NewTileSize > 2048 {
+		http.Error(w, "newTileSize must be between 16 and 2048", http.StatusBadRequest)
+		return
+	}
+
+	st := storeFor(r)
+	manifest, err := st.loadManifest(req.Folder)
+	if err != nil {
+		http.Error(w, "Error reading manifest.json: "+err.Error(), http.StatusNotFound)
+		return
+	}
+	if req.NewTileSize == manifest.effectiveTileSize() {
+		http.Error(w, "newTileSize is the puzzle's current tile size", http.StatusBadRequest)
+		return
+	}
+	opts, err := optionsFromManifest(manifest)
+	if err != nil {
+		http.Error(w, "Invalid tileNameTemplate in manifest.json: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	img, err := st.loadIndexImage(req.Folder, manifest)
+	if err != nil {
+		http.Error(w, "Error reading index image: "+err.Error(), http.StatusNotFound)
+		return
+	}
+
+	// The copy is as visible as the original
+	name := req.Folder
+	if entry, ok, err := st.findImageEntry(req.Folder); err == nil && ok {
+		name = entry.Name
+		opts.Private = entry.Private
+	}
+	opts.Name = fmt.Sprintf("%s (%dpx)", name, req.NewTileSize)
+	for i, width := range opts.ColumnWidths {
+		opts.ColumnWidths[i] = max(width*req.NewTileSize/opts.TileSize, 1)
+	}
+	opts.TileSize = req.NewTileSize
+	opts.ExtraResolutions = slices.DeleteFunc(opts.ExtraResolutions, func(size int) bool { return size == req.NewTileSize })
+	opts.UploaderIP = hashIP(parseClientIP(r, *trustProxy))
+	opts.CreatedAt = time.Time{}
+
+	folder, err := st.reserveFolder(fmt.Sprintf("%s_%d", req.Folder, req.NewTileSize), false)
+	if err != nil {
+		writeStatusError(w, err)
+		return
+	}
+	if err := buildPuzzle(st, folder, img, opts); err != nil {
+		writeStatusError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"status": "ok",
+		"folder": folder,
+		"_links": buildLinks(folder, r),
+	})
+}
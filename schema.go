@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/invopop/jsonschema"
+)
+
+// schemaTypes are the documents /schema can describe.
+var schemaTypes = map[string]any{
+	"manifest":      &Manifest{},
+	"imageIndex":    &ImageIndex{},
+	"exportPayload": &ExportPayload{},
+}
+
+// schemaHandler returns the draft-07 JSON Schema of manifest.json,
+// imageIndex.json or the /exportPuzzle payload, generated from the Go types.
+func schemaHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	v, ok := schemaTypes[r.URL.Query().Get("type")]
+	if !ok {
+		http.Error(w, "Invalid type: must be manifest, imageIndex or exportPayload", http.StatusBadRequest)
+		return
+	}
+
+	// Inline every definition so the schema needs no draft 2020-12 $defs
+	reflector := &jsonschema.Reflector{DoNotReference: true, ExpandedStruct: true}
+	schema := reflector.Reflect(v)
+	schema.Version = "http://json-schema.org/draft-07/schema#"
+
+	w.Header().Set("Content-Type", "application/schema+json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(schema)
+}
+
+// JSONSchemaExtend allows the plain filename form of a placement next to
+// the object form.
+func (PlacedTile) JSONSchemaExtend(s *jsonschema.Schema) {
+	object := *s
+	*s = jsonschema.Schema{OneOf: []*jsonschema.Schema{{Type: "string"}, &object}}
+}
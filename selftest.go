@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// checkPuzzle verifies that a puzzle's manifest parses, that every listed
+// piece exists on disk and that the solution only refers to listed pieces.
+func checkPuzzle(st *store, folder string) []string {
+	manifest, err := st.loadManifest(folder)
+	if err != nil {
+		return []string{"manifest.json: " + err.Error()}
+	}
+
+	var problems []string
+	pieces := make(map[string]bool)
+	for _, piece := range manifest.Pieces {
+		pieces[piece.File] = true
+		if _, err := os.Stat(filepath.Join(st.puzzlePath(folder), "pieces", piece.File)); err != nil {
+			problems = append(problems, "missing piece "+piece.File)
+		}
+	}
+	for pos, file := range manifest.Solution {
+		if !pieces[file] {
+			problems = append(problems, fmt.Sprintf("solution %s refers to unknown piece %s", pos, file))
+		}
+	}
+	return problems
+}
+
+// runSelfTests runs the self-test on every store.
+func runSelfTests() {
+	for _, st := range allStores() {
+		runSelfTest(st)
+	}
+}
+
+// runSelfTest checks every puzzle directory of a store and logs a summary.
+// It only reports problems; the server keeps running regardless.
+func runSelfTest(st *store) {
+	folders, err := st.puzzleFolders()
+	if err != nil {
+		log.Printf("Self-test: cannot read %s: %v", st.root, err)
+		return
+	}
+
+	var scanned int
+	var corrupt []string
+	for _, folder := range folders {
+		scanned++
+		if problems := checkPuzzle(st, folder); len(problems) > 0 {
+			corrupt = append(corrupt, folder)
+			log.Printf("Self-test: %s is corrupt: %s", folder, strings.Join(problems, "; "))
+		}
+	}
+
+	log.Printf("Scanned %d puzzles: %d OK, %d corrupt (%s)", scanned, scanned-len(corrupt), len(corrupt), st.root)
+	if len(corrupt) > 0 {
+		log.Printf("Corrupt puzzles: %s", strings.Join(corrupt, ", "))
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// shareKey returns the HMAC key share tokens are signed with. Without
+// -shareTokenSecret a random key is used, so tokens stop working when the
+// server restarts.
+var shareKey = sync.OnceValue(func() []byte {
+	if *shareTokenSecret != "" {
+		return []byte(*shareTokenSecret)
+	}
+	key := make([]byte, 32)
+	rand.Read(key)
+	log.Printf("INFO: no -shareTokenSecret set, share tokens are valid until restart")
+	return key
+})
+
+type shareClaims struct {
+	Folder string `json:"folder"`
+	jwt.RegisteredClaims
+}
+
+// validShareToken reports whether the request carries an unexpired share
+// token for folder in its ?token= parameter.
+func validShareToken(r *http.Request, folder string) bool {
+	raw := r.URL.Query().Get("token")
+	if raw == "" {
+		return false
+	}
+	var claims shareClaims
+	_, err := jwt.ParseWithClaims(raw, &claims, func(*jwt.Token) (any, error) {
+		return shareKey(), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Name}), jwt.WithExpirationRequired())
+	return err == nil && claims.Folder == folder
+}
+
+// canSeeSolution reports whether the request may see where a puzzle's
+// tiles belong: the solution and anything derived from it, such as the
+// neighbours or the position of a tile. Only admins and holders of a share
+// token for the puzzle may; other players check boards with /checkSolution.
+func canSeeSolution(r *http.Request, folder string) bool {
+	return isAdmin(r) || validShareToken(r, folder)
+}
+
+// puzzleVisible reports whether the request may see a puzzle of the store.
+// Private puzzles are visible to admins and with a share token only.
+func (st *store) puzzleVisible(r *http.Request, folder string) bool {
+	if isAdmin(r) || validShareToken(r, folder) {
+		return true
+	}
+	entry, ok, err := st.findImageEntry(folder)
+	return err == nil && !(ok && entry.Private)
+}
+
+// requirePuzzleVisible replies 404, as for a missing puzzle, and returns
+// false unless the request may see the puzzle. Handlers that read the
+// folder from their body call it once they have it.
+func requirePuzzleVisible(w http.ResponseWriter, r *http.Request, folder string) bool {
+	if !storeFor(r).puzzleVisible(r, folder) {
+		http.Error(w, "Puzzle not found", http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+// requirePuzzleAccess wraps a handler taking ?folder= so that private
+// puzzles look like missing ones to requests that may not see them.
+func requirePuzzleAccess(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		folder := r.URL.Query().Get("folder")
+		if validFolderName(folder) && !requirePuzzleVisible(w, r, folder) {
+			return
+		}
+		next(w, r)
+	}
+}
+
+// shareTokenHandler issues a signed JWT granting access to one puzzle until
+// it expires.
+func shareTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	folder := r.URL.Query().Get("folder")
+	if !validFolderName(folder) {
+		http.Error(w, "Invalid folder name", http.StatusBadRequest)
+		return
+	}
+	expiryHours, err := strconv.Atoi(formValueOr(r, "expiryHours", "24"))
+	if err != nil || expiryHours < 1 {
+		http.Error(w, "Invalid expiryHours: must be a positive integer", http.StatusBadRequest)
+		return
+	}
+	if _, err := storeFor(r).loadManifest(folder); err != nil {
+		http.Error(w, "Puzzle not found", http.StatusNotFound)
+		return
+	}
+
+	expiresAt := time.Now().Add(time.Duration(expiryHours) * time.Hour).UTC().Truncate(time.Second)
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, shareClaims{
+		Folder:           folder,
+		RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(expiresAt)},
+	}).SignedString(shareKey())
+	if err != nil {
+		http.Error(w, "Error signing token: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"token":     token,
+		"folder":    folder,
+		"expiresAt": expiresAt,
+	})
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPrivatePuzzleAccess(t *testing.T) {
+	mux, st := newTestMux(t)
+	setFlag(t, adminToken, "secret")
+	mustUpload(t, mux, encodePNG(t, testImage(128, 128, 1)), map[string]string{
+		"name": "foo", "columns": "2", "tileSize": "64", "private": "true",
+	})
+	mustUpload(t, mux, encodePNG(t, testImage(128, 128, 2)), map[string]string{
+		"name": "bar", "columns": "2", "tileSize": "64",
+	})
+	manifest, err := st.loadManifest("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := manifest.Pieces[0].File
+	token := shareTokenFor(t, mux, "foo")
+	otherToken := shareTokenFor(t, mux, "bar")
+
+	targets := []string{
+		"/tile?folder=foo&file=" + file,
+		"/manifest?folder=foo",
+		"/assemblyPreview?folder=foo",
+		"/images/foo/pieces/" + file + "?",
+		"/images/foo/?",
+		"/puzzleMeta?folder=foo",
+		"/puzzles/foo?",
+		"/puzzleStats?folder=foo",
+		"/downloadPieces?folder=foo",
+		"/embed?folder=foo",
+		"/prefetch?folder=foo",
+		"/gridOverlay?folder=foo",
+		"/tileAltText?folder=foo&file=" + file,
+		"/completions?folder=foo",
+		"/generationStatus?folder=foo",
+		"/exportPuzzleProgress?folder=foo",
+	}
+	for _, target := range targets {
+		t.Run(target, func(t *testing.T) {
+			if rec := get(mux, target); rec.Code != http.StatusNotFound {
+				t.Errorf("without a token: status %d, want 404", rec.Code)
+			}
+			if rec := get(mux, target+"&token="+otherToken); rec.Code != http.StatusNotFound {
+				t.Errorf("with another puzzle's token: status %d, want 404", rec.Code)
+			}
+			if rec := get(mux, target+"&token="+token); rec.Code != http.StatusOK {
+				t.Errorf("with a share token: status %d, want 200", rec.Code)
+			}
+			req := httptest.NewRequest(http.MethodGet, target, nil)
+			req.Header.Set("X-Admin-Token", "secret")
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Errorf("as admin: status %d, want 200", rec.Code)
+			}
+		})
+	}
+
+	t.Run("public puzzle", func(t *testing.T) {
+		if rec := get(mux, "/manifest?folder=bar"); rec.Code != http.StatusOK {
+			t.Errorf("status %d, want 200", rec.Code)
+		}
+	})
+
+	// Handlers reading the folder from their body, and the WebSockets,
+	// which a 404 stops before the upgrade
+	for _, target := range []string{"/exportPuzzle", "/checkSolution", "/exportTileset", "/benchmarkCompression", "/checkTileQuality", "/flagComplete", "/swapPieces", "/saveState"} {
+		t.Run(target, func(t *testing.T) {
+			if rec := postJSON(t, mux, target, map[string]any{"folder": "foo", "sessionId": "s1"}); rec.Code != http.StatusNotFound {
+				t.Errorf("without a token: status %d, want 404: %s", rec.Code, rec.Body)
+			}
+		})
+	}
+	for _, target := range []string{"/ws?folder=foo", "/leaderboardSocket?folder=foo"} {
+		if rec := get(mux, target); rec.Code != http.StatusNotFound {
+			t.Errorf("%s without a token: status %d, want 404", target, rec.Code)
+		}
+	}
+
+	t.Run("images listing", func(t *testing.T) {
+		body := get(mux, "/images/").Body.String()
+		if strings.Contains(body, "foo") || !strings.Contains(body, `href="bar/"`) {
+			t.Errorf("public listing:\n%s", body)
+		}
+		if strings.Contains(strings.ToLower(body), "imageindex") {
+			t.Errorf("listing shows imageIndex.json:\n%s", body)
+		}
+	})
+
+	t.Run("rescaled copy", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/rescalePuzzle", strings.NewReader(`{"folder":"foo","newTileSize":32}`))
+		req.Header.Set("X-Admin-Token", "secret")
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("rescale: %d %s", rec.Code, rec.Body)
+		}
+		var resp struct{ Folder string }
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		if rec := get(mux, "/puzzleMeta?folder="+resp.Folder); rec.Code != http.StatusNotFound {
+			t.Errorf("rescaled copy of a private puzzle: status %d, want 404", rec.Code)
+		}
+	})
+
+	t.Run("puzzle list", func(t *testing.T) {
+		var items []PuzzleListItem
+		if err := json.Unmarshal(get(mux, "/puzzles").Body.Bytes(), &items); err != nil {
+			t.Fatal(err)
+		}
+		if len(items) != 1 || items[0].Folder != "bar" {
+			t.Errorf("public list: %+v, want only bar", items)
+		}
+	})
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"image"
+	"image/draw"
+	"math"
+)
+
+// gaussianKernel returns a normalised 1D Gaussian kernel covering three
+// standard deviations either side of the centre.
+func gaussianKernel(sigma float64) []float64 {
+	radius := int(math.Ceil(3 * sigma))
+	kernel := make([]float64, 2*radius+1)
+	var sum float64
+	for i := range kernel {
+		x := float64(i - radius)
+		kernel[i] = math.Exp(-x * x / (2 * sigma * sigma))
+		sum += kernel[i]
+	}
+	for i := range kernel {
+		kernel[i] /= sum
+	}
+	return kernel
+}
+
+// toRGBA returns img as an *image.RGBA with its origin at 0,0.
+func toRGBA(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+	return dst
+}
+
+// gaussianBlur blurs all four channels of src with the given sigma. The
+// blur is separable, so it runs as a horizontal pass followed by a vertical
+// one; edge pixels are repeated.
+func gaussianBlur(src *image.RGBA, sigma float64) *image.RGBA {
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	kernel := gaussianKernel(sigma)
+	radius := len(kernel) / 2
+	clamp := func(v, hi int) int { return min(max(v, 0), hi-1) }
+
+	// Horizontal pass into a float buffer
+	tmp := make([]float64, w*h*4)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var acc [4]float64
+			for k, weight := range kernel {
+				i := src.PixOffset(clamp(x+k-radius, w), y)
+				for c := 0; c < 4; c++ {
+					acc[c] += weight * float64(src.Pix[i+c])
+				}
+			}
+			copy(tmp[(y*w+x)*4:], acc[:])
+		}
+	}
+
+	// Vertical pass
+	dst := image.NewRGBA(src.Bounds())
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var acc [4]float64
+			for k, weight := range kernel {
+				j := (clamp(y+k-radius, h)*w + x) * 4
+				for c := 0; c < 4; c++ {
+					acc[c] += weight * tmp[j+c]
+				}
+			}
+			i := dst.PixOffset(x, y)
+			for c := 0; c < 4; c++ {
+				dst.Pix[i+c] = uint8(math.Round(min(max(acc[c], 0), 255)))
+			}
+		}
+	}
+	return dst
+}
+
+// unsharpMask sharpens img by adding amount times the difference between
+// the image and a Gaussian blurred copy. Alpha is left alone.
+func unsharpMask(img image.Image, sigma, amount float64) *image.RGBA {
+	src := toRGBA(img)
+	blurred := gaussianBlur(src, sigma)
+
+	dst := image.NewRGBA(src.Bounds())
+	for i := 0; i < len(src.Pix); i += 4 {
+		alpha := float64(src.Pix[i+3]) // colours are premultiplied
+		for c := 0; c < 3; c++ {
+			orig := float64(src.Pix[i+c])
+			v := orig + amount*(orig-float64(blurred.Pix[i+c]))
+			dst.Pix[i+c] = uint8(math.Round(min(max(v, 0), alpha)))
+		}
+		dst.Pix[i+3] = src.Pix[i+3]
+	}
+	return dst
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+// BenchmarkDenoise slices a 2048px wide image into more and more tiles,
+// with and without a denoiseRadius of 2, to show what the blur costs.
+func BenchmarkDenoise(b *testing.B) {
+	img := testImage(2048, 2048, 1)
+	for _, columns := range []int{4, 8, 16} {
+		for _, radius := range []int{0, 2} {
+			opts := defaultPuzzleOptions(httptest.NewRequest("POST", "/uploadPuzzle", nil))
+			opts.Name, opts.Columns, opts.TileSize, opts.DenoiseRadius = "bench", columns, 2048/columns, radius
+			name := "tiles=" + strconv.Itoa(columns*columns) + "/denoiseRadius=" + strconv.Itoa(radius)
+			b.Run(name, func(b *testing.B) {
+				benchmarkSlice(b, img, opts)
+			})
+		}
+	}
+}
@@ -0,0 +1,683 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/nfnt/resize"
+)
+
+// puzzleOptions are the settings used to slice an image into a puzzle.
+type puzzleOptions struct {
+	Name             string
+	Columns          int
+	ColumnWidths     []int // per-column tile widths; all TileSize when empty
+	TileSize         int
+	Description      string
+	Tags             []string
+	IndexFormat      string // "jpeg" or "png"
+	TileFormat       string // how tiles are encoded: "png" or "jpeg"
+	TileNameTemplate string
+	ComputeNeighbors bool
+	ThumbnailSize    int
+	ComputeEntropy   bool
+	ExtraResolutions []int // additional tile sizes, each saved to pieces_<size>/
+	DenoiseRadius    int   // Gaussian blur sigma applied before slicing; 0 is off
+	UnsharpMask      bool
+	UnsharpAmount    float64
+	UploaderIP       string    // hashed
+	OnConflict       string    // "error" or "suffix" when the folder already exists
+	Async            bool      // slice in the background and report progress via /uploadProgress
+	Private          bool      // hidden from everyone but admins and share token holders
+	CreatedAt        time.Time // zero means now
+
+	tileNames *template.Template
+	progress  func(done, total int) // called after each tile when set
+	phash     string                // dHash of the uploaded image, for imageIndex.json
+	denoised  bool                  // the image is an index image, already blurred by DenoiseRadius
+}
+
+// defaultPuzzleOptions returns the options used when a request does not
+// override them.
+func defaultPuzzleOptions(r *http.Request) puzzleOptions {
+	tileNames, _ := parseTileNameTemplate("")
+	return puzzleOptions{
+		TileSize:      512,
+		TileFormat:    "png",
+		IndexFormat:   "jpeg",
+		OnConflict:    "error",
+		ThumbnailSize: defaultThumbnailSize,
+		UnsharpAmount: 0.5,
+		UploaderIP:    hashIP(parseClientIP(r, *trustProxy)),
+		tileNames:     tileNames,
+	}
+}
+
+// parsePuzzleOptions reads the slicing options from an upload form.
+func parsePuzzleOptions(r *http.Request) (puzzleOptions, error) {
+	opts := defaultPuzzleOptions(r)
+	var err error
+
+	// Get puzzle name
+	opts.Name = r.FormValue("name")
+	if opts.Name == "" {
+		return opts, errors.New("Puzzle name is required")
+	}
+
+	// Get optional per-column tile widths, e.g. "512,256,512" or "[512,256,512]"
+	opts.ColumnWidths, err = parseIntList(r.FormValue("columnWidths"))
+	if err != nil || len(opts.ColumnWidths) > 100 || slices.ContainsFunc(opts.ColumnWidths, func(w int) bool { return w < 16 || w > 2048 }) {
+		return opts, errors.New("Invalid columnWidths: at most 100 widths between 16 and 2048")
+	}
+
+	// Get columns value, which defaults to the number of column widths
+	columns := r.FormValue("columns")
+	if columns == "" && len(opts.ColumnWidths) > 0 {
+		columns = strconv.Itoa(len(opts.ColumnWidths))
+	}
+	opts.Columns, err = strconv.Atoi(columns)
+	if err != nil || opts.Columns <= 0 {
+		return opts, errors.New("Invalid number of columns")
+	}
+	if len(opts.ColumnWidths) > 0 && opts.Columns != len(opts.ColumnWidths) {
+		return opts, errors.New("columns must match the number of columnWidths")
+	}
+
+	// Get tile size
+	if sizeStr := r.FormValue("tileSize"); sizeStr != "" {
+		opts.TileSize, err = strconv.Atoi(sizeStr)
+		if err != nil || !validTileSize(opts.TileSize) {
+			return opts, errors.New("Invalid tileSize: must be 64, 128, 256, 512 or 1024")
+		}
+	}
+
+	// Get optional description and comma separated tags
+	opts.Description = strings.TrimSpace(r.FormValue("description"))
+	for _, tag := range strings.Split(r.FormValue("tags"), ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			opts.Tags = append(opts.Tags, tag)
+		}
+	}
+
+	// Get index image format
+	opts.IndexFormat = formValueOr(r, "indexFormat", "jpeg")
+	if opts.IndexFormat != "jpeg" && opts.IndexFormat != "png" {
+		return opts, errors.New("Invalid indexFormat: must be jpeg or png")
+	}
+
+	// Get tile format; JPEG tiles are named .jpg unless a template says
+	// otherwise
+	opts.TileFormat = formValueOr(r, "tileFormat", "png")
+	if _, ok := tileContentTypes[opts.TileFormat]; !ok {
+		return opts, errors.New("Invalid tileFormat: must be png or jpeg")
+	}
+
+	// Get tile naming template
+	opts.TileNameTemplate = r.FormValue("tileNameTemplate")
+	if opts.TileNameTemplate == "" && opts.TileFormat == "jpeg" {
+		opts.TileNameTemplate = strings.TrimSuffix(defaultTileNameTemplate, ".png") + ".jpg"
+	}
+	opts.tileNames, err = parseTileNameTemplate(opts.TileNameTemplate)
+	if err != nil {
+		return opts, errors.New("Invalid tileNameTemplate: " + err.Error())
+	}
+
+	// Get neighbour metadata option
+	opts.ComputeNeighbors, err = strconv.ParseBool(formValueOr(r, "computeNeighbors", "false"))
+	if err != nil {
+		return opts, errors.New("Invalid computeNeighbors: must be true or false")
+	}
+
+	// Get thumbnail size
+	if thumbnailSizeStr := r.FormValue("thumbnailSize"); thumbnailSizeStr != "" {
+		opts.ThumbnailSize, err = strconv.Atoi(thumbnailSizeStr)
+		if err != nil || opts.ThumbnailSize <= 0 || opts.ThumbnailSize > 2048 {
+			return opts, errors.New("Invalid thumbnailSize")
+		}
+	}
+
+	// Get entropy option
+	opts.ComputeEntropy, err = strconv.ParseBool(formValueOr(r, "computeEntropy", "false"))
+	if err != nil {
+		return opts, errors.New("Invalid computeEntropy: must be true or false")
+	}
+
+	// Get noise reduction option
+	if denoiseStr := r.FormValue("denoiseRadius"); denoiseStr != "" {
+		opts.DenoiseRadius, err = strconv.Atoi(denoiseStr)
+		if err != nil || opts.DenoiseRadius < 0 || opts.DenoiseRadius > 5 {
+			return opts, errors.New("Invalid denoiseRadius: must be between 0 and 5")
+		}
+	}
+
+	// Get sharpening options
+	opts.UnsharpMask, err = strconv.ParseBool(formValueOr(r, "unsharpMask", "false"))
+	if err != nil {
+		return opts, errors.New("Invalid unsharpMask: must be true or false")
+	}
+	if amountStr := r.FormValue("unsharpAmount"); amountStr != "" {
+		opts.UnsharpAmount, err = strconv.ParseFloat(amountStr, 64)
+		if err != nil || opts.UnsharpAmount < 0 || opts.UnsharpAmount > 5 {
+			return opts, errors.New("Invalid unsharpAmount: must be between 0 and 5")
+		}
+	}
+
+	// Get extra tile resolutions, e.g. "256" or "[256,128]"
+	extra, err := parseIntList(r.FormValue("extraResolutions"))
+	for i, size := range extra {
+		if err != nil || size < 16 || size > 2048 || size == opts.TileSize || slices.Contains(extra[:i], size) {
+			return opts, fmt.Errorf("Invalid extraResolutions: sizes must be distinct, between 16 and 2048 and not %d", opts.TileSize)
+		}
+	}
+	if err != nil {
+		return opts, fmt.Errorf("Invalid extraResolutions: %v", err)
+	}
+	opts.ExtraResolutions = extra
+
+	// Get folder name conflict strategy
+	opts.OnConflict = formValueOr(r, "onConflict", "error")
+	if opts.OnConflict != "error" && opts.OnConflict != "suffix" {
+		return opts, errors.New("Invalid onConflict: must be error or suffix")
+	}
+
+	// Get async option
+	opts.Async, err = strconv.ParseBool(formValueOr(r, "async", "false"))
+	if err != nil {
+		return opts, errors.New("Invalid async: must be true or false")
+	}
+
+	// Get visibility
+	opts.Private, err = strconv.ParseBool(formValueOr(r, "private", "false"))
+	if err != nil {
+		return opts, errors.New("Invalid private: must be true or false")
+	}
+
+	return opts, nil
+}
+
+// validTileSize reports whether size is a power of two from 64 to 1024.
+func validTileSize(size int) bool {
+	return size >= 64 && size <= 1024 && size&(size-1) == 0
+}
+
+// parseIntList parses a comma separated list of integers, optionally in
+// square brackets. An empty string gives a nil list.
+func parseIntList(s string) ([]int, error) {
+	s = strings.Trim(s, "[] ")
+	if s == "" {
+		return nil, nil
+	}
+	var list []int
+	for _, part := range strings.Split(s, ",") {
+		v, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+	}
+	return list, nil
+}
+
+// optionsFromManifest returns the options a puzzle was sliced with, so it
+// can be sliced again from its index image. The index image is saved after
+// denoising, so it is not blurred again, but the radius stays recorded.
+func optionsFromManifest(manifest *Manifest) (puzzleOptions, error) {
+	tileNames, err := parseTileNameTemplate(manifest.TileNameTemplate)
+	if err != nil {
+		return puzzleOptions{}, err
+	}
+	_, cols := manifest.gridSize()
+	indexFormat := manifest.IndexFormat
+	if indexFormat == "" {
+		indexFormat = "jpeg"
+	}
+	tileFormat := manifest.TileFormat
+	if tileFormat == "" {
+		tileFormat = "png"
+	}
+	var extraResolutions []int
+	for sizeStr := range manifest.Resolutions {
+		if size, err := strconv.Atoi(sizeStr); err == nil && size != manifest.effectiveTileSize() {
+			extraResolutions = append(extraResolutions, size)
+		}
+	}
+	slices.Sort(extraResolutions)
+	return puzzleOptions{
+		Columns:          cols,
+		ColumnWidths:     manifest.ColumnWidths,
+		TileSize:         manifest.effectiveTileSize(),
+		Description:      manifest.Description,
+		Tags:             manifest.Tags,
+		IndexFormat:      indexFormat,
+		TileFormat:       tileFormat,
+		TileNameTemplate: manifest.TileNameTemplate,
+		ComputeNeighbors: manifest.Neighbors != nil,
+		ThumbnailSize:    defaultThumbnailSize,
+		UploaderIP:       manifest.UploaderIP,
+		ComputeEntropy:   manifest.Entropy > 0,
+		DenoiseRadius:    manifest.DenoiseRadius,
+		ExtraResolutions: extraResolutions,
+		CreatedAt:        manifest.CreatedAt,
+		tileNames:        tileNames,
+		denoised:         true,
+	}, nil
+}
+
+// statusError is an error that maps to a specific HTTP status.
+type statusError struct {
+	status int
+	msg    string
+}
+
+func (e *statusError) Error() string { return e.msg }
+
+// writeStatusError reports err to the client, as a 500 unless it is a
+// statusError.
+func writeStatusError(w http.ResponseWriter, err error) {
+	var se *statusError
+	if errors.As(err, &se) {
+		http.Error(w, se.msg, se.status)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+// folderLocks holds a *sync.Mutex for each puzzle directory path that has
+// been locked, so that uploads, deletes and restores of one folder run one at a
+// time.
+var folderLocks sync.Map
+
+// lockFolder locks a puzzle folder name and returns the unlock function.
+func (st *store) lockFolder(folder string) func() {
+	v, _ := folderLocks.LoadOrStore(st.puzzlePath(folder), &sync.Mutex{})
+	mu := v.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
+}
+
+// reserveFolder creates the directory of a new puzzle named folder. If it
+// already exists the upload fails with 409, or with suffix set the first
+// free folder_2 ... folder_<maxNameSuffix> is used instead.
+func (st *store) reserveFolder(folder string, suffix bool) (string, error) {
+	if !validFolderName(folder) {
+		return "", &statusError{http.StatusBadRequest, "Invalid puzzle name"}
+	}
+	for i := 1; i <= *maxNameSuffix; i++ {
+		name := folder
+		if i > 1 {
+			name = fmt.Sprintf("%s_%d", folder, i)
+		}
+		path := st.puzzlePath(name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return "", fmt.Errorf("Error creating puzzle directory: %v", err)
+		}
+		err := os.Mkdir(path, 0755)
+		if err == nil {
+			return name, nil
+		}
+		if !os.IsExist(err) {
+			return "", fmt.Errorf("Error creating puzzle directory: %v", err)
+		}
+		if !suffix {
+			break
+		}
+	}
+	return "", &statusError{http.StatusConflict, "A puzzle named " + folder + " already exists"}
+}
+
+// slicePuzzle resizes img, saves the index image, thumbnail and tiles into
+// the puzzle folder and writes its manifest.json. It does not touch
+// imageIndex.json.
+func slicePuzzle(st *store, folder string, img image.Image, opts puzzleOptions) (*Manifest, error) {
+	tileSize := opts.TileSize
+
+	var entropy float64
+	if opts.ComputeEntropy {
+		entropy = imageEntropy(img)
+	}
+
+	// Resize the image
+	originalBounds := img.Bounds()
+	originalWidth := originalBounds.Dx()
+	originalHeight := originalBounds.Dy()
+
+	targetWidth := tileSize * opts.Columns
+	if len(opts.ColumnWidths) > 0 {
+		targetWidth = 0
+		for _, w := range opts.ColumnWidths {
+			targetWidth += w
+		}
+	}
+	aspectRatio := float64(originalWidth) / float64(originalHeight)
+	targetHeight := int(float64(targetWidth) / aspectRatio)
+
+	var resizedImg image.Image = resize.Resize(uint(targetWidth), uint(targetHeight), img, resize.Lanczos3)
+	if opts.DenoiseRadius > 0 && !opts.denoised {
+		resizedImg = gaussianBlur(toRGBA(resizedImg), float64(opts.DenoiseRadius))
+	}
+	if opts.UnsharpMask {
+		resizedImg = unsharpMask(resizedImg, 1.0, opts.UnsharpAmount)
+	}
+
+	// Create puzzle directory
+	puzzlePath := st.puzzlePath(folder)
+	if err := os.MkdirAll(filepath.Join(puzzlePath, "pieces"), 0755); err != nil {
+		return nil, fmt.Errorf("Error creating puzzle directory: %v", err)
+	}
+
+	// Save original image as index.jpg (or index.png)
+	indexName := indexFileName(opts.IndexFormat)
+	indexFile, err := os.Create(filepath.Join(puzzlePath, indexName))
+	if err != nil {
+		return nil, fmt.Errorf("Error creating %s: %v", indexName, err)
+	}
+	// We need to encode the resized image
+	if opts.IndexFormat == "png" {
+		err = png.Encode(indexFile, resizedImg)
+	} else {
+		err = jpeg.Encode(indexFile, resizedImg, nil)
+	}
+	indexFile.Close()
+	if err != nil {
+		return nil, fmt.Errorf("Error saving %s: %v", indexName, err)
+	}
+
+	// Save a thumbnail that fits within ThumbnailSize x ThumbnailSize
+	thumbImg := generateThumbnail(resizedImg, opts.ThumbnailSize)
+	thumbFile, err := os.Create(filepath.Join(puzzlePath, "thumb.jpg"))
+	if err != nil {
+		return nil, fmt.Errorf("Error creating thumb.jpg: %v", err)
+	}
+	err = jpeg.Encode(thumbFile, thumbImg, &jpeg.Options{Quality: 75})
+	thumbFile.Close()
+	if err != nil {
+		return nil, fmt.Errorf("Error saving thumb.jpg: %v", err)
+	}
+
+	// Slice the image into tiles
+	bounds := resizedImg.Bounds()
+	cols := (bounds.Max.X + tileSize - 1) / tileSize
+	rows := (bounds.Max.Y + tileSize - 1) / tileSize
+	if len(opts.ColumnWidths) > 0 {
+		cols = len(opts.ColumnWidths)
+	}
+	// colX[c] is the left edge of column c, colX[cols] the right edge
+	colX := make([]int, cols+1)
+	for c := 1; c <= cols; c++ {
+		width := tileSize
+		if len(opts.ColumnWidths) > 0 {
+			width = opts.ColumnWidths[c-1]
+		}
+		colX[c] = min(colX[c-1]+width, bounds.Max.X)
+	}
+
+	var pieces []PieceInfo
+	solution := make(map[string]string)
+	usedNames := make(map[string]bool)
+	var totalSize int64
+	var pieceEntropies map[string]float64
+	if opts.ComputeEntropy {
+		pieceEntropies = make(map[string]float64)
+	}
+
+	results := sliceTiles(filepath.Join(puzzlePath, "pieces"), resizedImg, rows, colX, tileSize, opts)
+	for _, res := range results {
+		err := res.err
+		if err == nil && usedNames[res.name] {
+			err = &statusError{http.StatusBadRequest, fmt.Sprintf("Invalid tileNameTemplate: name %q is used by more than one tile", res.name)}
+		}
+		if err != nil {
+			if errors.As(err, new(*statusError)) {
+				os.RemoveAll(puzzlePath)
+			}
+			return nil, err
+		}
+		usedNames[res.name] = true
+
+		pieces = append(pieces, PieceInfo{File: res.name, SizeBytes: res.size})
+		totalSize += res.size
+		solution[fmt.Sprintf("%d,%d", res.row, res.col)] = res.name
+		if pieceEntropies != nil {
+			pieceEntropies[res.name] = res.entropy
+		}
+	}
+
+	if opts.CreatedAt.IsZero() {
+		opts.CreatedAt = time.Now().UTC()
+	}
+
+	// Create manifest.json
+	manifest := &Manifest{
+		SchemaVersion:       manifestSchemaVersion,
+		Pieces:              pieces,
+		Solution:            solution,
+		TileSize:            tileSize,
+		Rows:                rows,
+		Cols:                cols,
+		ResizeAlgorithm:     "lanczos3",
+		IndexFormat:         opts.IndexFormat,
+		UploaderIP:          opts.UploaderIP,
+		TileNameTemplate:    opts.TileNameTemplate,
+		TileFormat:          opts.TileFormat,
+		Description:         opts.Description,
+		Tags:                opts.Tags,
+		Difficulty:          computeDifficulty(rows, cols, entropy),
+		Entropy:             entropy,
+		PieceEntropies:      pieceEntropies,
+		CreatedAt:           opts.CreatedAt,
+		TotalPieceSizeBytes: totalSize,
+		ColumnWidths:        opts.ColumnWidths,
+		DenoiseRadius:       opts.DenoiseRadius,
+	}
+	if opts.ComputeNeighbors {
+		manifest.Neighbors = buildNeighbors(solution)
+	}
+
+	// Slice the extra resolutions, reusing the primary tile names
+	if len(opts.ExtraResolutions) > 0 {
+		manifest.Resolutions = map[string]string{strconv.Itoa(tileSize): "pieces"}
+		for _, size := range opts.ExtraResolutions {
+			dir := fmt.Sprintf("pieces_%d", size)
+			if err := sliceResolution(filepath.Join(puzzlePath, dir), resizedImg, tileSize, size, colX, solution, opts.TileFormat); err != nil {
+				return nil, fmt.Errorf("Error slicing %dpx tiles: %v", size, err)
+			}
+			manifest.Resolutions[strconv.Itoa(size)] = dir
+		}
+	}
+
+	if err := st.writeManifest(folder, manifest); err != nil {
+		return nil, fmt.Errorf("Error creating manifest.json: %v", err)
+	}
+	return manifest, nil
+}
+
+// defaultThumbnailSize is the longest side of thumb.jpg unless the upload
+// sets thumbnailSize.
+const defaultThumbnailSize = 256
+
+// generateThumbnail scales src down so that its longest side is at most
+// maxDim. Images that already fit are returned as they are.
+func generateThumbnail(src image.Image, maxDim int) image.Image {
+	b := src.Bounds()
+	if b.Dx() <= maxDim && b.Dy() <= maxDim {
+		return src
+	}
+	scale := float64(maxDim) / float64(max(b.Dx(), b.Dy()))
+	width := max(1, int(math.Round(float64(b.Dx())*scale)))
+	height := max(1, int(math.Round(float64(b.Dy())*scale)))
+	return resizeImage(src, width, height)
+}
+
+// tileJob is one tile for sliceTiles to cut out, encode and write.
+type tileJob struct {
+	index, row, col int
+	rect            image.Rectangle
+}
+
+type tileResult struct {
+	tileJob
+	name    string
+	size    int64
+	entropy float64
+	err     error
+}
+
+// sliceWorkers is how many tiles sliceTiles cuts out at once.
+var sliceWorkers = runtime.NumCPU()
+
+// sliceTiles cuts img into rows of tileSize and the columns starting at
+// colX and writes each tile to dir, using sliceWorkers workers. The results are
+// in row-major order whichever tile finished first. Results after a failed
+// tile are still returned; the caller stops at the first error.
+func sliceTiles(dir string, img image.Image, rows int, colX []int, tileSize int, opts puzzleOptions) []tileResult {
+	cols := len(colX) - 1
+	total := rows * cols
+	bounds := img.Bounds()
+
+	// Both channels hold every tile, so no worker ever blocks and none is
+	// left behind when a tile fails
+	jobs := make(chan tileJob, total)
+	done := make(chan tileResult, total)
+	for r := 0; r < rows; r++ {
+		for c := 0; c < cols; c++ {
+			y1 := min((r+1)*tileSize, bounds.Max.Y)
+			jobs <- tileJob{index: r*cols + c, row: r, col: c, rect: image.Rect(colX[c], r*tileSize, colX[c+1], y1)}
+		}
+	}
+	close(jobs)
+
+	for i := 0; i < min(sliceWorkers, total); i++ {
+		go func() {
+			for job := range jobs {
+				done <- sliceTile(dir, img, job, opts)
+			}
+		}()
+	}
+
+	results := make([]tileResult, total)
+	for n := 1; n <= total; n++ {
+		res := <-done
+		results[res.index] = res
+		if opts.progress != nil {
+			opts.progress(n, total)
+		}
+	}
+	return results
+}
+
+func sliceTile(dir string, img image.Image, job tileJob, opts puzzleOptions) tileResult {
+	res := tileResult{tileJob: job}
+	tileImg := image.NewRGBA(job.rect)
+	draw.Draw(tileImg, job.rect, img, job.rect.Min, draw.Src)
+
+	// Encode the tile first so its hash is available for naming
+	var tileBuf bytes.Buffer
+	if err := encodeTile(&tileBuf, tileImg, opts.TileFormat); err != nil {
+		res.err = fmt.Errorf("Error encoding tile: %v", err)
+		return res
+	}
+	name, err := renderTileName(opts.tileNames, tileNameData{
+		Index: job.index,
+		Row:   job.row,
+		Col:   job.col,
+		Hash:  tileHash(tileBuf.Bytes()),
+	})
+	if err != nil {
+		res.err = &statusError{http.StatusBadRequest, "Invalid tileNameTemplate: " + err.Error()}
+		return res
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, name), tileBuf.Bytes(), 0644); err != nil {
+		res.err = fmt.Errorf("Error creating tile file: %v", err)
+		return res
+	}
+	res.name = name
+	res.size = int64(tileBuf.Len())
+	if opts.ComputeEntropy {
+		res.entropy = imageEntropy(tileImg)
+	}
+	return res
+}
+
+// sliceResolution scales img, which was sliced into tileSize rows and
+// columns starting at colX, so that its tiles shrink or grow by
+// size/tileSize and writes each one to dir, in tileFormat, under the name the
+// solution gives its position.
+func sliceResolution(dir string, img image.Image, tileSize, size int, colX []int, solution map[string]string, tileFormat string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	b := img.Bounds()
+	scaled := resize.Resize(uint(b.Dx()*size/tileSize), uint(b.Dy()*size/tileSize), img, resize.Lanczos3)
+	sb := scaled.Bounds()
+
+	for pos, name := range solution {
+		var r, c int
+		fmt.Sscanf(pos, "%d,%d", &r, &c)
+		tileRect := image.Rect(colX[c]*size/tileSize, r*size, colX[c+1]*size/tileSize, (r+1)*size).Intersect(sb)
+		if tileRect.Empty() {
+			continue
+		}
+		tileImg := image.NewRGBA(tileRect)
+		draw.Draw(tileImg, tileRect, scaled, tileRect.Min, draw.Src)
+
+		var buf bytes.Buffer
+		if err := encodeTile(&buf, tileImg, tileFormat); err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), buf.Bytes(), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newImageEntry builds the imageIndex.json entry of a freshly sliced puzzle.
+func newImageEntry(name, folder string, manifest *Manifest) ImageEntry {
+	return ImageEntry{
+		Name:       name,
+		Folder:     folder,
+		Rows:       manifest.Rows,
+		Cols:       manifest.Cols,
+		Tl:         manifest.Solution["0,0"], // The first tile is the top-left
+		Index:      indexFileName(manifest.IndexFormat),
+		UploaderIP: manifest.UploaderIP,
+		ThumbPath:  folder + "/thumb.jpg",
+		Thumb:      "thumb.jpg",
+	}
+}
+
+// indexFileName returns the name of the index image for an index format.
+func indexFileName(format string) string {
+	if format == "png" {
+		return "index.png"
+	}
+	return "index.jpg"
+}
+
+// loadIndexImage decodes the index image of a puzzle.
+func (st *store) loadIndexImage(folder string, manifest *Manifest) (image.Image, error) {
+	f, err := os.Open(filepath.Join(st.puzzlePath(folder), indexFileName(manifest.IndexFormat)))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	return img, err
+}
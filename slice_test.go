@@ -0,0 +1,38 @@
+package main
+
+import (
+	"image"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+)
+
+// benchmarkSlice slices img into a puzzle b.N times, as an upload does.
+func benchmarkSlice(b *testing.B, img image.Image, opts puzzleOptions) {
+	b.Helper()
+	st := &store{root: b.TempDir()}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := slicePuzzle(st, "bench", img, opts); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkSliceWorkers slices a 4096x4096 image into 8 columns of 512px
+// tiles with one worker and with one per CPU. Compare the two to see what
+// the pool gains.
+func BenchmarkSliceWorkers(b *testing.B) {
+	img := testImage(4096, 4096, 1)
+	opts := defaultPuzzleOptions(httptest.NewRequest("POST", "/uploadPuzzle", nil))
+	opts.Name, opts.Columns = "bench", 8
+
+	b.Run("sequential", func(b *testing.B) {
+		setFlag(b, &sliceWorkers, 1)
+		benchmarkSlice(b, img, opts)
+	})
+	b.Run("parallel", func(b *testing.B) {
+		setFlag(b, &sliceWorkers, runtime.NumCPU())
+		benchmarkSlice(b, img, opts)
+	})
+}
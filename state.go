@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// SavedState is a player's in-progress board, stored server side as
+// images/<folder>/state_<sessionId>.json.
+type SavedState struct {
+	SessionID  string            `json:"sessionId"`
+	Folder     string            `json:"folder"`
+	Placements map[string]string `json:"placements"` // "row,col":"filename"
+	ElapsedSec int               `json:"elapsedSec"`
+	SavedAt    time.Time         `json:"savedAt"`
+	LastHintAt *time.Time        `json:"lastHintAt,omitempty"` // set by /hint, never by the client
+}
+
+// stateMutex serialises read-modify-write updates of saved states.
+var stateMutex sync.Mutex
+
+// validSessionID accepts short IDs made of letters, digits, '-' and '_'.
+func validSessionID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+func (st *store) statePath(folder, sessionID string) string {
+	return filepath.Join(st.puzzlePath(folder), "state_"+sessionID+".json")
+}
+
+// loadState reads a saved state. A missing file is an empty state.
+func (st *store) loadState(folder, sessionID string) (SavedState, error) {
+	state := SavedState{SessionID: sessionID, Folder: folder, Placements: map[string]string{}}
+	data, err := os.ReadFile(st.statePath(folder, sessionID))
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+	err = json.Unmarshal(data, &state)
+	// The file may predate a rename of the puzzle
+	state.Folder, state.SessionID = folder, sessionID
+	return state, err
+}
+
+func (st *store) writeState(state SavedState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(st.statePath(state.Folder, state.SessionID), data, 0644)
+}
+
+func saveStateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var state SavedState
+	if err := json.NewDecoder(r.Body).Decode(&state); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !validFolderName(state.Folder) || !validSessionID(state.SessionID) {
+		http.Error(w, "Invalid folder or sessionId", http.StatusBadRequest)
+		return
+	}
+	if !requirePuzzleVisible(w, r, state.Folder) {
+		return
+	}
+
+	st := storeFor(r)
+	if _, err := os.Stat(filepath.Join(st.puzzlePath(state.Folder), "manifest.json")); err != nil {
+		http.Error(w, "Puzzle not found", http.StatusNotFound)
+		return
+	}
+
+	if state.Placements == nil {
+		state.Placements = map[string]string{}
+	}
+	state.SavedAt = time.Now().UTC()
+
+	// Keep the hint cooldown out of the client's hands
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	if previous, err := st.loadState(state.Folder, state.SessionID); err == nil {
+		state.LastHintAt = previous.LastHintAt
+	}
+	if err := st.writeState(state); err != nil {
+		http.Error(w, "Error writing state: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"status": "ok",
+		"_links": buildLinks(state.Folder, r),
+	})
+}
+
+func loadStateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	folder := r.URL.Query().Get("folder")
+	sessionID := r.URL.Query().Get("sessionId")
+	if !validFolderName(folder) || !validSessionID(sessionID) {
+		http.Error(w, "Invalid folder or sessionId", http.StatusBadRequest)
+		return
+	}
+
+	data, err := os.ReadFile(storeFor(r).statePath(folder, sessionID))
+	if os.IsNotExist(err) {
+		http.Error(w, "No saved state", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error reading state: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// PuzzleProgress is a puzzle's shared in-progress board, stored as
+// images/<folder>/progress.json. Unlike a SavedState it belongs to no
+// session.
+type PuzzleProgress struct {
+	Folder     string            `json:"folder,omitempty"`
+	Placements map[string]string `json:"placements"` // "row,col":"filename"
+}
+
+func (st *store) progressPath(folder string) string {
+	return filepath.Join(st.puzzlePath(folder), "progress.json")
+}
+
+// listedPuzzle reports whether folder is a live puzzle in imageIndex.json.
+func (st *store) listedPuzzle(folder string) (bool, error) {
+	entry, found, err := st.findImageEntry(folder)
+	return found && !entry.Deleted, err
+}
+
+func savePuzzleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var progress PuzzleProgress
+	if err := json.NewDecoder(r.Body).Decode(&progress); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !validFolderName(progress.Folder) {
+		http.Error(w, "Invalid folder name", http.StatusBadRequest)
+		return
+	}
+	if !requirePuzzleVisible(w, r, progress.Folder) {
+		return
+	}
+
+	st := storeFor(r)
+	defer st.lockFolder(progress.Folder)()
+	if listed, err := st.listedPuzzle(progress.Folder); err != nil {
+		http.Error(w, "Error reading imageIndex.json: "+err.Error(), http.StatusInternalServerError)
+		return
+	} else if !listed {
+		http.Error(w, "Puzzle not found", http.StatusNotFound)
+		return
+	}
+	manifest, err := st.loadManifest(progress.Folder)
+	if err != nil {
+		http.Error(w, "Error reading manifest.json: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	files := make([]string, 0, len(progress.Placements))
+	for _, file := range progress.Placements {
+		files = append(files, file)
+	}
+	if unknown := manifest.unknownPieces(files); len(unknown) > 0 {
+		writeUnknownPieces(w, http.StatusUnprocessableEntity, unknown)
+		return
+	}
+
+	if progress.Placements == nil {
+		progress.Placements = map[string]string{}
+	}
+	data, err := json.Marshal(progress)
+	if err != nil {
+		http.Error(w, "Error encoding progress: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := os.WriteFile(st.progressPath(progress.Folder), data, 0644); err != nil {
+		http.Error(w, "Error writing progress.json: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"status": "ok",
+		"_links": buildLinks(progress.Folder, r),
+	})
+}
+
+// loadPuzzleHandler returns a puzzle's progress.json, or an empty board
+// when nothing has been saved yet.
+func loadPuzzleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	folder := r.URL.Query().Get("folder")
+	if !validFolderName(folder) {
+		http.Error(w, "Invalid folder name", http.StatusBadRequest)
+		return
+	}
+
+	st := storeFor(r)
+	defer st.lockFolder(folder)()
+	if listed, err := st.listedPuzzle(folder); err != nil {
+		http.Error(w, "Error reading imageIndex.json: "+err.Error(), http.StatusInternalServerError)
+		return
+	} else if !listed {
+		http.Error(w, "Puzzle not found", http.StatusNotFound)
+		return
+	}
+
+	progress := PuzzleProgress{Folder: folder, Placements: map[string]string{}}
+	data, err := os.ReadFile(st.progressPath(folder))
+	if err != nil && !os.IsNotExist(err) {
+		http.Error(w, "Error reading progress.json: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &progress); err != nil {
+			http.Error(w, "Error reading progress.json: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	progress.Folder = folder // the file may predate a rename of the puzzle
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(progress)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// PuzzleStats is the response of GET /puzzleStats.
+type PuzzleStats struct {
+	Folder              string      `json:"folder"`
+	PieceCount          int         `json:"pieceCount"`
+	Pieces              []PieceInfo `json:"pieces"`
+	TotalPieceSizeBytes int64       `json:"totalPieceSizeBytes"`
+}
+
+// puzzleStatsHandler reports the storage used by a puzzle's tiles. Sizes
+// missing from older manifests are read from disk.
+func puzzleStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	folder := r.URL.Query().Get("folder")
+	if !validFolderName(folder) {
+		http.Error(w, "Invalid folder name", http.StatusBadRequest)
+		return
+	}
+
+	st := storeFor(r)
+	manifest, err := st.loadManifest(folder)
+	if err != nil {
+		http.Error(w, "Error reading manifest.json: "+err.Error(), http.StatusNotFound)
+		return
+	}
+
+	stats := PuzzleStats{Folder: folder, PieceCount: len(manifest.Pieces), Pieces: []PieceInfo{}}
+	for _, piece := range manifest.Pieces {
+		if piece.SizeBytes == 0 {
+			if info, err := os.Stat(filepath.Join(st.puzzlePath(folder), "pieces", piece.File)); err == nil {
+				piece.SizeBytes = info.Size()
+			}
+		}
+		stats.Pieces = append(stats.Pieces, piece)
+		stats.TotalPieceSizeBytes += piece.SizeBytes
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// store is a directory of puzzles with its own imageIndex.json. There is a
+// single store unless the server runs with -multiTenant, in which case each
+// tenant gets one below the images directory.
+type store struct {
+	root   string // directory holding imageIndex.json and the puzzle folders
+	prefix string // URL prefix of the routes serving this store
+}
+
+// defaultStore is the single store, or the parent of the tenant stores. Its
+// root is set from -data in main.
+var defaultStore = &store{root: "images"}
+
+type storeContextKey struct{}
+
+// storeFor returns the store a request operates on.
+func storeFor(r *http.Request) *store {
+	if st, ok := r.Context().Value(storeContextKey{}).(*store); ok {
+		return st
+	}
+	return defaultStore
+}
+
+// allStores returns every store on disk: the default store, or one per
+// tenant directory in multi-tenant mode.
+func allStores() []*store {
+	if !*multiTenant {
+		return []*store{defaultStore}
+	}
+	entries, err := os.ReadDir(defaultStore.root)
+	if err != nil {
+		log.Printf("Cannot read images directory: %v", err)
+		return nil
+	}
+	var stores []*store
+	for _, entry := range entries {
+		if entry.IsDir() && validTenantID(entry.Name()) {
+			stores = append(stores, &store{
+				root:   filepath.Join(defaultStore.root, entry.Name()),
+				prefix: "/api/v1/" + entry.Name(),
+			})
+		}
+	}
+	return stores
+}
+
+// puzzleFolders lists the puzzle directories of the store.
+func (st *store) puzzleFolders() ([]string, error) {
+	if *storageMode != "nested" {
+		return listDirs(st.root)
+	}
+	shards, err := listDirs(st.root)
+	if err != nil {
+		return nil, err
+	}
+	var folders []string
+	for _, shard := range shards {
+		names, err := listDirs(filepath.Join(st.root, shard))
+		if err != nil {
+			return nil, err
+		}
+		folders = append(folders, names...)
+	}
+	return folders, nil
+}
+
+// listDirs returns the names of the non-hidden subdirectories of dir.
+func listDirs(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+// puzzlePath returns the directory of a puzzle folder. In nested storage
+// mode puzzles are sharded by the first two characters of their folder name,
+// like Git's object store.
+func (st *store) puzzlePath(folder string) string {
+	if *storageMode == "nested" {
+		shard := folder
+		if len(shard) > 2 {
+			shard = shard[:2]
+		}
+		return filepath.Join(st.root, shard, folder)
+	}
+	return filepath.Join(st.root, folder)
+}
+
+// imagesURL returns the URL under which a file of the store is served.
+func (st *store) imagesURL(rel string) string {
+	return st.prefix + "/images/" + rel
+}
+
+// validTenantID reports whether id is a non-empty alphanumeric tenant ID.
+func validTenantID(id string) bool {
+	if id == "" {
+		return false
+	}
+	for _, r := range id {
+		if r > unicode.MaxASCII || !(unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return true
+}
+
+// tenantHandler serves /api/v1/{tenantId}/... by stripping the prefix and
+// passing the request to api with the tenant's store in its context.
+func tenantHandler(api http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rest := strings.TrimPrefix(r.URL.Path, "/api/v1/")
+		tenantID, path, _ := strings.Cut(rest, "/")
+		if !validTenantID(tenantID) {
+			http.Error(w, "Invalid tenant ID", http.StatusBadRequest)
+			return
+		}
+
+		st := &store{
+			root:   filepath.Join(defaultStore.root, tenantID),
+			prefix: "/api/v1/" + tenantID,
+		}
+		r2 := r.WithContext(context.WithValue(r.Context(), storeContextKey{}, st))
+		r2.URL.Path = "/" + path
+		r2.URL.RawPath = ""
+		api.ServeHTTP(w, r2)
+	})
+}
+
+// privateFiles are never served by imagesHandler: manifest.json holds the
+// solution, which only /manifest hands out, and only to those allowed it;
+// imageIndex.json holds uploader hashes, and /puzzles lists it without them.
+var privateFiles = map[string]bool{
+	"manifest.json":   true,
+	"imageindex.json": true,
+}
+
+// imagesHandler serves the files of the request's store. Puzzle files keep
+// their /images/<folder>/... URLs whatever the storage mode.
+func imagesHandler(w http.ResponseWriter, r *http.Request) {
+	st := storeFor(r)
+	rel := strings.TrimPrefix(r.URL.Path, "/images/")
+	if rel == "" {
+		writePuzzleListing(w, r, st)
+		return
+	}
+	folder, _, found := strings.Cut(rel, "/")
+	// Dot files and directories (.trash, .exports, the IP hash key) are
+	// never served either
+	hidden := strings.HasPrefix(rel, ".") || strings.Contains(rel, "/.")
+	if hidden || privateFiles[strings.ToLower(path.Base(rel))] || !st.puzzleVisible(r, folder) {
+		http.NotFound(w, r)
+		return
+	}
+	if found && *storageMode == "nested" && validFolderName(folder) {
+		http.StripPrefix("/images/"+folder+"/", http.FileServer(http.Dir(st.puzzlePath(folder)))).ServeHTTP(w, r)
+		return
+	}
+	http.StripPrefix("/images/", http.FileServer(http.Dir(st.root))).ServeHTTP(w, r)
+}
+
+// writePuzzleListing lists the puzzle folders the request may see, in the
+// format of http.FileServer's directory listings. The store's own files and
+// private puzzles are left out.
+func writePuzzleListing(w http.ResponseWriter, r *http.Request, st *store) {
+	folders, err := st.puzzleFolders()
+	if err != nil && !os.IsNotExist(err) {
+		http.Error(w, "Error listing puzzles: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sort.Strings(folders)
+	imageIndexMutex.Lock()
+	imageIndex, err := st.loadImageIndex()
+	imageIndexMutex.Unlock()
+	if err != nil {
+		http.Error(w, "Error reading imageIndex.json: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	private := map[string]bool{}
+	for _, entry := range imageIndex.Images {
+		if !entry.Deleted {
+			private[entry.Folder] = entry.Private
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintln(w, "<!doctype html>\n<meta name=\"viewport\" content=\"width=device-width\">\n<pre>")
+	for _, folder := range folders {
+		if !private[folder] || isAdmin(r) || validShareToken(r, folder) {
+			fmt.Fprintf(w, "<a href=\"%s/\">%s/</a>\n", url.PathEscape(folder), html.EscapeString(folder))
+		}
+	}
+	fmt.Fprintln(w, "</pre>")
+}
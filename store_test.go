@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestImagesHandlerHidesPrivateFiles(t *testing.T) {
+	mux, _ := newTestMux(t)
+	mustUpload(t, mux, encodePNG(t, testImage(128, 128, 1)), map[string]string{
+		"name": "foo", "columns": "2", "tileSize": "64",
+	})
+
+	hashIP("192.0.2.1") // creates the IP hash key file
+	for _, target := range []string{
+		"/images/foo/manifest.json",
+		"/images/foo/MANIFEST.JSON",
+		"/images/imageIndex.json",
+		"/images/" + ipHashKeyFile,
+		"/images/.trash/",
+		"/images/foo/.hidden",
+	} {
+		if rec := get(mux, target); rec.Code != http.StatusNotFound {
+			t.Errorf("GET %s: status %d, want 404", target, rec.Code)
+		}
+	}
+	if rec := get(mux, "/images/foo/pieces/"); rec.Code != http.StatusOK {
+		t.Errorf("GET /images/foo/pieces/: status %d, want 200", rec.Code)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// tileContentTypes maps manifest tileFormat values to MIME types.
+var tileContentTypes = map[string]string{
+	"png":  "image/png",
+	"jpeg": "image/jpeg",
+}
+
+// tileJPEGQuality is the quality of tiles of puzzles sliced with
+// tileFormat=jpeg.
+const tileJPEGQuality = 90
+
+// encodeTile writes a tile in a tileFormat, or as PNG when the format is
+// empty.
+func encodeTile(w io.Writer, img image.Image, format string) error {
+	if format == "jpeg" {
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: tileJPEGQuality})
+	}
+	return png.Encode(w, img)
+}
+
+// tileHandler serves GET /tile?folder=<name>&file=<tile>. The Content-Type
+// comes from the manifest's tileFormat, or is sniffed from the file for
+// puzzles without one.
+func tileHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	folder := r.URL.Query().Get("folder")
+	if !validFolderName(folder) {
+		http.Error(w, "Invalid folder name", http.StatusBadRequest)
+		return
+	}
+	file := r.URL.Query().Get("file")
+	if file == "" || filepath.Base(file) != file || strings.HasPrefix(file, ".") {
+		http.Error(w, "Invalid file name", http.StatusBadRequest)
+		return
+	}
+
+	st := storeFor(r)
+	var contentType string
+	if manifest, err := st.loadManifest(folder); err == nil {
+		if !slices.ContainsFunc(manifest.Pieces, func(p PieceInfo) bool { return p.File == file }) {
+			http.Error(w, "Tile not found", http.StatusNotFound)
+			return
+		}
+		contentType = tileContentTypes[manifest.TileFormat]
+	}
+
+	data, err := os.ReadFile(filepath.Join(st.puzzlePath(folder), "pieces", file))
+	if err != nil {
+		http.Error(w, "Tile not found", http.StatusNotFound)
+		return
+	}
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	http.ServeContent(w, r, file, time.Time{}, bytes.NewReader(data))
+}
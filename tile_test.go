@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestTileContentType(t *testing.T) {
+	mux, st := newTestMux(t)
+	tests := []struct {
+		tileFormat  string
+		contentType string
+		extension   string
+	}{
+		{"", "image/png", ".png"},
+		{"png", "image/png", ".png"},
+		{"jpeg", "image/jpeg", ".jpg"},
+	}
+	for i, tt := range tests {
+		t.Run("tileFormat="+tt.tileFormat, func(t *testing.T) {
+			folder := "puzzle" + tt.tileFormat
+			fields := map[string]string{"name": folder, "columns": "2", "tileSize": "64"}
+			if tt.tileFormat != "" {
+				fields["tileFormat"] = tt.tileFormat
+			}
+			mustUpload(t, mux, encodePNG(t, testImage(128, 128, i+1)), fields)
+			manifest, err := st.loadManifest(folder)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			file := manifest.Solution["0,0"]
+			if !strings.HasSuffix(file, tt.extension) {
+				t.Errorf("tile %s, want a %s name", file, tt.extension)
+			}
+			rec := get(mux, "/tile?folder="+folder+"&file="+file)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != tt.contentType {
+				t.Errorf("Content-Type %q, want %q", ct, tt.contentType)
+			}
+			if sniffed := http.DetectContentType(rec.Body.Bytes()); sniffed != tt.contentType {
+				t.Errorf("tile is %s", sniffed)
+			}
+		})
+	}
+
+	t.Run("invalid tileFormat", func(t *testing.T) {
+		rec := uploadTestPuzzle(t, mux, encodePNG(t, testImage(128, 128, 9)), map[string]string{"name": "gif", "columns": "2", "tileFormat": "gif"})
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("status %d, want 400", rec.Code)
+		}
+	})
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// defaultTileNameTemplate reproduces the original image_NNNN.png naming.
+const defaultTileNameTemplate = `image_{{printf "%04d" .Index}}.png`
+
+// tileNameData holds the variables available to a tile name template.
+type tileNameData struct {
+	Index int    // sequential tile number in row-major order
+	Row   int    // zero based row
+	Col   int    // zero based column
+	Hash  string // hash of the encoded tile
+}
+
+const (
+	maxTileNameTemplateLength = 256
+	maxTileNameLength         = 128
+)
+
+// tileNameFields are the fields of tileNameData a template may use.
+var tileNameFields = map[string]bool{"Index": true, "Row": true, "Col": true, "Hash": true}
+
+// printfFlags matches the flags, width and precision of a printf verb.
+var printfFlags = regexp.MustCompile(`%[-+# 0]*([0-9*]*)(?:\.([0-9*]*))?`)
+
+// parseTileNameTemplate parses a tile naming template, falling back to the
+// default naming when text is empty. The template is test rendered so that
+// obviously broken templates are rejected before any slicing happens.
+func parseTileNameTemplate(text string) (*template.Template, error) {
+	if text == "" {
+		text = defaultTileNameTemplate
+	}
+	if len(text) > maxTileNameTemplateLength {
+		return nil, fmt.Errorf("template longer than %d bytes", maxTileNameTemplateLength)
+	}
+	tmpl, err := template.New("tileName").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkTileNameTree(tmpl); err != nil {
+		return nil, err
+	}
+	if _, err := renderTileName(tmpl, tileNameData{Hash: tileHash(nil)}); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// checkTileNameTree restricts a template to text and actions printing the
+// tileNameData fields, directly or through printf with short widths. Loops,
+// conditionals, variables and nested templates are refused, since templates
+// come from unauthenticated uploads and are rendered once per tile.
+func checkTileNameTree(tmpl *template.Template) error {
+	if len(tmpl.Templates()) > 1 {
+		return errors.New("define and block are not allowed")
+	}
+	for _, node := range tmpl.Tree.Root.Nodes {
+		switch node := node.(type) {
+		case *parse.TextNode:
+		case *parse.ActionNode:
+			if len(node.Pipe.Decl) > 0 {
+				return errors.New("variables are not allowed")
+			}
+			for _, cmd := range node.Pipe.Cmds {
+				if err := checkTileNameCommand(cmd); err != nil {
+					return err
+				}
+			}
+		default:
+			return fmt.Errorf("only {{.Field}} and {{printf}} actions are allowed, not %q", node.String())
+		}
+	}
+	return nil
+}
+
+func checkTileNameCommand(cmd *parse.CommandNode) error {
+	for i, arg := range cmd.Args {
+		switch arg := arg.(type) {
+		case *parse.FieldNode:
+			if len(arg.Ident) != 1 || !tileNameFields[arg.Ident[0]] {
+				return fmt.Errorf("unknown field %s: use .Index, .Row, .Col or .Hash", arg)
+			}
+		case *parse.IdentifierNode:
+			if arg.Ident != "printf" || i != 0 {
+				return fmt.Errorf("function %s is not allowed, only printf", arg.Ident)
+			}
+		case *parse.StringNode:
+			for _, m := range printfFlags.FindAllStringSubmatch(arg.Text, -1) {
+				if strings.Contains(m[0], "*") || len(m[1]) > 2 || len(m[2]) > 2 {
+					return fmt.Errorf("format %q: widths and precisions must be at most 2 digits", m[0])
+				}
+			}
+		default:
+			return fmt.Errorf("%s is not allowed in a tile name template", arg)
+		}
+	}
+	return nil
+}
+
+// renderTileName executes a tile name template and checks that the result
+// is a short, plain file name.
+func renderTileName(tmpl *template.Template, data tileNameData) (string, error) {
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, data); err != nil {
+		return "", err
+	}
+	name := sb.String()
+	if name == "" {
+		return "", errors.New("template produced an empty name")
+	}
+	if len(name) > maxTileNameLength {
+		return "", fmt.Errorf("name %q is longer than %d bytes", name[:maxTileNameLength]+"...", maxTileNameLength)
+	}
+	if strings.HasPrefix(name, ".") || strings.ContainsAny(name, `/\`) || strings.Contains(name, "..") {
+		return "", fmt.Errorf("name %q must not start with a dot or contain path separators or ..", name)
+	}
+	return name, nil
+}
+
+// tileHash returns a short content hash used for the {{.Hash}} variable.
+func tileHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestParseTileNameTemplate(t *testing.T) {
+	valid := map[string]string{
+		"":                                 "image_0003.png",
+		`tile_{{.Row}}_{{.Col}}.png`:       "tile_0_3.png",
+		`{{printf "%02d-%02d" .Row .Col}}`: "00-03",
+		`{{.Index | printf "%03d"}}.png`:   "003.png",
+		`{{.Hash}}.png`:                    tileHash(nil) + ".png",
+		`{{- .Index -}}.jpg`:               "3.jpg",
+		`{{printf "%-4d|" .Index}}x`:       "3   |x",
+	}
+	for text, want := range valid {
+		tmpl, err := parseTileNameTemplate(text)
+		if err != nil {
+			t.Errorf("%q: %v", text, err)
+			continue
+		}
+		got, err := renderTileName(tmpl, tileNameData{Index: 3, Col: 3, Hash: tileHash(nil)})
+		if err != nil || got != want {
+			t.Errorf("%q rendered %q, %v; want %q", text, got, err, want)
+		}
+	}
+
+	invalid := []string{
+		`{{range 3}}a{{end}}.png`,
+		`{{range $i := .Index}}a{{end}}`,
+		`{{if .Row}}a{{end}}.png`,
+		`{{with .Hash}}{{.}}{{end}}`,
+		`{{define "x"}}a{{end}}{{template "x"}}.png`,
+		`{{block "x" .}}a{{end}}`,
+		`{{$x := .Index}}{{$x}}`,
+		`{{.}}`,
+		`{{len .Hash}}.png`,
+		`{{printf "%0999999d" .Index}}`,
+		`{{printf "%.999f" .Index}}`,
+		`{{printf "%*d" .Index .Row}}`,
+		`{{.Missing}}.png`,
+		`{{(printf "%d" .Index)}}`,
+		strings.Repeat("a", maxTileNameLength+1),
+		strings.Repeat("a", maxTileNameTemplateLength+1),
+		".",
+		"..",
+		".hidden.png",
+		"a/b.png",
+		`a\b.png`,
+	}
+	for _, text := range invalid {
+		if _, err := parseTileNameTemplate(text); err == nil {
+			t.Errorf("%q accepted", text)
+		}
+	}
+}
+
+func TestUploadRejectsLoopingTemplate(t *testing.T) {
+	mux, _ := newTestMux(t)
+	rec := uploadTestPuzzle(t, mux, encodePNG(t, testImage(128, 128, 1)), map[string]string{
+		"name": "foo", "columns": "2", "tileSize": "64", "tileNameTemplate": `{{range 1000000000}}a{{end}}.png`,
+	})
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status %d, want 400: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestExportTilesetRejectsLoopingTemplate(t *testing.T) {
+	mux, _ := newTestMux(t)
+	mustUpload(t, mux, encodePNG(t, testImage(128, 128, 1)), map[string]string{"name": "foo", "columns": "2", "tileSize": "64"})
+	for _, text := range []string{`{{range 1000000000}}a{{end}}.png`, `{{printf "%0999999999d" .Index}}`} {
+		rec := postJSON(t, mux, "/exportTileset", ExportTilesetRequest{Folder: "foo", NameTemplate: text})
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%q: status %d, want 400: %s", text, rec.Code, rec.Body)
+		}
+	}
+	rec := postJSON(t, mux, "/exportTileset", ExportTilesetRequest{Folder: "foo", NameTemplate: `t_{{.Row}}_{{.Col}}.png`})
+	if rec.Code != http.StatusOK {
+		t.Errorf("valid template: status %d: %s", rec.Code, rec.Body)
+	}
+}
@@ -1,395 +1,704 @@
-package main
-
-import (
-	"bytes"
-	"embed"
-	"fmt"
-	"io"
-	"log"
-	"net/http"
-	"os"
-
-	"github.com/gorilla/websocket"
-	"github.com/toqueteos/webbrowser"
-
-	"encoding/json"
-	"image"
-	"image/draw"
-	"image/jpeg"
-	"image/png"
-	"path/filepath"
-	"strconv"
-	"strings"
-	"sync"
-	"unicode"
-
-	"github.com/nfnt/resize"
-)
-
-// Configure the WebSocket upgrader
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		// Allow all connections for this simple proxy
-		return true
-	},
-}
-
-//go:embed tilepuzzler.html
-var embeddedFS embed.FS
-
-func main() {
-	// Ensure the images directory exists
-	if err := os.MkdirAll("images", 0755); err != nil {
-		log.Fatalf("Failed to create images directory: %v", err)
-	}
-
-	http.HandleFunc("/", serveSPA)
-	http.HandleFunc("/exportPuzzle", exportPuzzleHandler)
-	http.HandleFunc("/uploadPuzzle", uploadPuzzleHandler)
-	imagesHandler := http.StripPrefix("/images/", http.FileServer(http.Dir("./images")))
-	http.Handle("/images/", imagesHandler)
-
-	port := "8080"
-	fmt.Printf("Starting TilePuzzler server on http://localhost:%s\n", port)
-	webbrowser.Open("http://localhost:" + port)
-	if err := http.ListenAndServe(":"+port, nil); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
-	}
-}
-
-// Helper: Resize image
-func resizeImage(img image.Image, width, height int) image.Image {
-	return resize.Resize(uint(width), uint(height), img, resize.Lanczos3)
-}
-
-type ExportPayload struct {
-	Folder     string            `json:"folder"`
-	Placements map[string]string `json:"placements"` // "row,col":"filename"
-}
-
-func serveSPA(w http.ResponseWriter, r *http.Request) {
-	if r.URL.Path != "/" {
-		http.NotFound(w, r)
-		return
-	}
-
-	// Check if an external tilepuzzler.html exists
-	_, err := os.Stat("tilepuzzler.html")
-	if err == nil {
-		// If it exists, serve the external file.
-		// This allows for easy development and customization without rebuilding.
-		log.Println("Serving external tilepuzzler.html")
-		http.ServeFile(w, r, "tilepuzzler.html")
-		return
-	}
-
-	// If the external file doesn't exist, serve the embedded version.
-	log.Println("Serving embedded tilepuzzler.html")
-	file, err := embeddedFS.Open("tilepuzzler.html")
-	if err != nil {
-		log.Printf("FATAL: Could not open embedded tilepuzzler.html: %v", err)
-		http.Error(w, "Internal Server Error: Embedded file not found.", http.StatusInternalServerError)
-		return
-	}
-	defer file.Close()
-
-	stat, err := file.Stat()
-	if err != nil {
-		log.Printf("FATAL: Could not stat embedded tilepuzzler.html: %v", err)
-		http.Error(w, "Internal Server Error: Cannot stat embedded file.", http.StatusInternalServerError)
-		return
-	}
-
-	// Read the file content into a buffer to create an io.ReadSeeker, which http.ServeContent needs.
-	content, err := io.ReadAll(file)
-	if err != nil {
-		log.Printf("FATAL: Could not read embedded tilepuzzler.html: %v", err)
-		http.Error(w, "Internal Server Error: Cannot read embedded file.", http.StatusInternalServerError)
-		return
-	}
-	reader := bytes.NewReader(content)
-
-	// Use http.ServeContent to handle caching headers correctly.
-	http.ServeContent(w, r, "tilepuzzler.html", stat.ModTime(), reader)
-}
-
-func exportPuzzleHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "POST required", http.StatusMethodNotAllowed)
-		return
-	}
-
-	var payload ExportPayload
-	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
-		return
-	}
-	fmt.Printf("Exporting %s\n", payload.Folder)
-	basePath := filepath.Join("images", payload.Folder)
-	tileSize := 512
-
-	// Determine canvas size
-	var maxRow, maxCol int
-	for pos := range payload.Placements {
-		var r, c int
-		fmt.Sscanf(pos, "%d,%d", &r, &c)
-		if r > maxRow {
-			maxRow = r
-		}
-		if c > maxCol {
-			maxCol = c
-		}
-	}
-	canvasW := (maxCol + 1) * tileSize
-	canvasH := (maxRow + 1) * tileSize
-
-	dst := image.NewRGBA(image.Rect(0, 0, canvasW, canvasH))
-
-	for pos, filename := range payload.Placements {
-		var r, c int
-		fmt.Sscanf(pos, "%d,%d", &r, &c)
-
-		tilePath := filepath.Join(basePath, "pieces", filename)
-		fmt.Printf("adding %s\n", filename)
-
-		tileFile, err := os.Open(tilePath)
-		if err != nil {
-			log.Printf("Failed to open tile %s: %v", filename, err)
-			continue
-		}
-		img, _, err := image.Decode(tileFile)
-		tileFile.Close()
-		if err != nil {
-			log.Printf("Failed to decode tile %s: %v", filename, err)
-			continue
-		}
-
-		pt := image.Pt(c*tileSize, r*tileSize)
-		draw.Draw(dst, image.Rectangle{Min: pt, Max: pt.Add(img.Bounds().Size())}, img, image.Point{}, draw.Over)
-	}
-	fmt.Printf("returning completed image\n")
-
-	w.Header().Set("Content-Type", "image/png")
-	w.Header().Set("Content-Disposition", `attachment; filename="puzzle.png"`)
-	if err := png.Encode(w, dst); err != nil {
-		http.Error(w, "Failed to encode PNG: "+err.Error(), http.StatusInternalServerError)
-	}
-}
-
-func uploadPuzzleHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "POST required", http.StatusMethodNotAllowed)
-		return
-	}
-
-	// Parse the multipart form
-	err := r.ParseMultipartForm(10 << 20) // 10 MB
-	if err != nil {
-		http.Error(w, "Error parsing multipart form: "+err.Error(), http.StatusBadRequest)
-		return
-	}
-
-	// Get puzzle name
-	puzzleName := r.FormValue("name")
-	if puzzleName == "" {
-		http.Error(w, "Puzzle name is required", http.StatusBadRequest)
-		return
-	}
-
-	// Get columns value
-	columnsStr := r.FormValue("columns")
-	columns, err := strconv.Atoi(columnsStr)
-	if err != nil || columns <= 0 {
-		http.Error(w, "Invalid number of columns", http.StatusBadRequest)
-		return
-	}
-
-	// Get the image file
-	file, _, err := r.FormFile("image")
-	if err != nil {
-		http.Error(w, "Error retrieving the file: "+err.Error(), http.StatusBadRequest)
-		return
-	}
-	defer file.Close()
-
-	// Decode the image
-	img, _, err := image.Decode(file)
-	if err != nil {
-		http.Error(w, "Error decoding image: "+err.Error(), http.StatusBadRequest)
-		return
-	}
-
-	const tileSize = 512
-
-	// Resize the image
-	originalBounds := img.Bounds()
-	originalWidth := originalBounds.Dx()
-	originalHeight := originalBounds.Dy()
-
-	targetWidth := tileSize * columns
-	aspectRatio := float64(originalWidth) / float64(originalHeight)
-	targetHeight := int(float64(targetWidth) / aspectRatio)
-
-	resizedImg := resize.Resize(uint(targetWidth), uint(targetHeight), img, resize.Lanczos3)
-
-	// Create puzzle directory
-	puzzleDirName := toSnakeCase(puzzleName)
-	puzzlePath := filepath.Join("images", puzzleDirName)
-	if err := os.MkdirAll(filepath.Join(puzzlePath, "pieces"), 0755); err != nil {
-		http.Error(w, "Error creating puzzle directory: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	// Save original image as index.jpg
-	indexPath := filepath.Join(puzzlePath, "index.jpg")
-	indexFile, err := os.Create(indexPath)
-	if err != nil {
-		http.Error(w, "Error creating index.jpg: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-	defer indexFile.Close()
-	// We need to encode the resized image
-	if err := jpeg.Encode(indexFile, resizedImg, nil); err != nil {
-		http.Error(w, "Error saving index.jpg: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	// Slice the image into tiles
-	bounds := resizedImg.Bounds()
-	cols := (bounds.Max.X + tileSize - 1) / tileSize
-	rows := (bounds.Max.Y + tileSize - 1) / tileSize
-
-	type PieceInfo struct {
-		File string `json:"file"`
-	}
-	var pieces []PieceInfo
-	solution := make(map[string]string)
-
-	for r := 0; r < rows; r++ {
-		for c := 0; c < cols; c++ {
-			x0 := c * tileSize
-			y0 := r * tileSize
-			x1 := x0 + tileSize
-			y1 := y0 + tileSize
-
-			if x1 > bounds.Max.X {
-				x1 = bounds.Max.X
-			}
-			if y1 > bounds.Max.Y {
-				y1 = bounds.Max.Y
-			}
-
-			tileRect := image.Rect(x0, y0, x1, y1)
-			tileImg := image.NewRGBA(tileRect)
-			draw.Draw(tileImg, tileRect, resizedImg, image.Point{x0, y0}, draw.Src)
-
-			// Save the tile
-			tileName := fmt.Sprintf("image_%04d.png", len(pieces))
-			tilePath := filepath.Join(puzzlePath, "pieces", tileName)
-			tileFile, err := os.Create(tilePath)
-			if err != nil {
-				http.Error(w, "Error creating tile file: "+err.Error(), http.StatusInternalServerError)
-				return
-			}
-			png.Encode(tileFile, tileImg)
-			tileFile.Close()
-
-			pieces = append(pieces, PieceInfo{File: tileName})
-			solution[fmt.Sprintf("%d,%d", r, c)] = tileName
-		}
-	}
-
-	// Create manifest.json
-	type Manifest struct {
-		Pieces   []PieceInfo       `json:"pieces"`
-		Solution map[string]string `json:"solution"`
-	}
-	manifest := Manifest{Pieces: pieces, Solution: solution}
-	manifestPath := filepath.Join(puzzlePath, "manifest.json")
-	manifestFile, err := os.Create(manifestPath)
-	if err != nil {
-		http.Error(w, "Error creating manifest.json: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-	defer manifestFile.Close()
-	json.NewEncoder(manifestFile).Encode(manifest)
-
-	// Update imageIndex.json
-	imageIndexMutex.Lock()
-	defer imageIndexMutex.Unlock()
-
-	type ImageIndex struct {
-		Images []struct {
-			Name   string `json:"name"`
-			Folder string `json:"folder"`
-			Rows   int    `json:"rows"`
-			Cols   int    `json:"cols"`
-			Tl     string `json:"tl"`
-		} `json:"images"`
-	}
-	imageIndexPath := filepath.Join("images", "imageIndex.json")
-	var imageIndex ImageIndex
-	imageIndexFile, err := os.ReadFile(imageIndexPath)
-	if err != nil && !os.IsNotExist(err) {
-		http.Error(w, "Error reading imageIndex.json: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-	if len(imageIndexFile) > 0 {
-		if err := json.Unmarshal(imageIndexFile, &imageIndex); err != nil {
-			http.Error(w, "Error parsing imageIndex.json: "+err.Error(), http.StatusInternalServerError)
-			return
-		}
-	}
-
-	newImage := struct {
-		Name   string `json:"name"`
-		Folder string `json:"folder"`
-		Rows   int    `json:"rows"`
-		Cols   int    `json:"cols"`
-		Tl     string `json:"tl"`
-	}{
-		Name:   puzzleName,
-		Folder: puzzleDirName,
-		Rows:   rows,
-		Cols:   cols,
-		Tl:     "image_0000.png", // Assuming the first tile is the top-left
-	}
-	imageIndex.Images = append(imageIndex.Images, newImage)
-
-	updatedImageIndex, err := json.MarshalIndent(imageIndex, "", "  ")
-	if err != nil {
-		http.Error(w, "Error marshalling imageIndex.json: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-	if err := os.WriteFile(imageIndexPath, updatedImageIndex, 0644); err != nil {
-		http.Error(w, "Error writing imageIndex.json: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	// Return success response
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
-}
-
-func toSnakeCase(s string) string {
-	var result strings.Builder
-	for i, r := range s {
-		if unicode.IsUpper(r) {
-			if i > 0 {
-				result.WriteRune('_')
-			}
-			result.WriteRune(unicode.ToLower(r))
-		} else if r == ' ' || r == '-' {
-			result.WriteRune('_')
-		} else {
-			result.WriteRune(r)
-		}
-	}
-	return result.String()
-}
-
-var (
-	imageIndexMutex sync.Mutex
-)
+package main
+
+import (
+	"bytes"
+	"embed"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/gorilla/websocket"
+	"github.com/toqueteos/webbrowser"
+
+	"encoding/json"
+	"errors"
+	"image"
+	"image/draw"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/nfnt/resize"
+)
+
+// Configure the WebSocket upgrader
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin: func(r *http.Request) bool {
+		// Allow all connections for this simple proxy
+		return true
+	},
+}
+
+//go:embed tilepuzzler.html
+var embeddedFS embed.FS
+
+var (
+	adminToken          = flag.String("adminToken", "", "token required by admin endpoints (admin endpoints are disabled when empty)")
+	multiTenant         = flag.Bool("multiTenant", false, "serve a separate puzzle library per tenant under /api/v1/{tenantId}/")
+	allowIndexing       = flag.Bool("allowIndexing", false, "let search engines index the site from /robots.txt")
+	selfTest            = flag.Bool("selfTest", true, "check every puzzle manifest in the background at startup")
+	trustProxy          = flag.Bool("trustProxy", false, "take the client IP from X-Forwarded-For or X-Real-IP when running behind a proxy")
+	maxExportsPerPuzzle = flag.Int("maxExportsPerPuzzle", 2, "how many exports of the same puzzle may be assembled at once")
+	pluginDir           = flag.String("pluginDir", "", "directory of Go plugins (.so files) to load at startup")
+	requireApiKey       = flag.Bool("requireApiKey", false, "require an API key (Authorization: ApiKey <key>) or the admin token for uploads")
+	maxNameSuffix       = flag.Int("maxNameSuffix", 10, "highest _N suffix tried for uploads with onConflict=suffix")
+	shareTokenSecret    = flag.String("shareTokenSecret", "", "HMAC secret for /shareToken JWTs (a random one is used when empty, so tokens do not survive a restart)")
+	ipHashSecret        = flag.String("ipHashSecret", "", "HMAC secret uploader IPs are hashed with (a random one is kept in the images directory when empty)")
+	hintCooldownSec     = flag.Int("hintCooldownSec", 30, "seconds a session must wait between /hint requests")
+	daemon              = flag.Bool("daemon", false, "run the server in the background and write its PID to -pidFile")
+	pidFile             = flag.String("pidFile", "tilepuzzler.pid", "PID file written by -daemon and read by -stop")
+	stop                = flag.Bool("stop", false, "stop the server started with -daemon and exit")
+	storageMode         = flag.String("storageMode", "flat", "puzzle directory layout: flat (images/<folder>) or nested (images/<first2chars>/<folder>); existing puzzles are not moved")
+	port                = flag.String("port", "8080", "port to listen on")
+	dataDir             = flag.String("data", "./images", "directory holding imageIndex.json and the puzzles")
+	noBrowser           = flag.Bool("no-browser", false, "do not open the browser at startup, e.g. when running headless or in a container")
+)
+
+func main() {
+	flag.Parse()
+	if *stop {
+		if err := stopDaemon(*pidFile); err != nil {
+			log.Fatalf("Failed to stop server: %v", err)
+		}
+		return
+	}
+	if *storageMode != "flat" && *storageMode != "nested" {
+		log.Fatalf("Invalid -storageMode %q: must be flat or nested", *storageMode)
+	}
+	if *maxExportsPerPuzzle <= 0 {
+		log.Fatalf("Invalid -maxExportsPerPuzzle %d: must be at least 1", *maxExportsPerPuzzle)
+	}
+	if *daemon {
+		if err := daemonize(*pidFile); err != nil {
+			log.Fatalf("Failed to start in the background: %v", err)
+		}
+		return
+	}
+
+	// Ensure the images directory exists. On a read-only filesystem a
+	// pre-populated images directory is good enough.
+	defaultStore.root = filepath.Clean(*dataDir)
+	if err := os.MkdirAll(defaultStore.root, 0755); err != nil {
+		if info, statErr := os.Stat(defaultStore.root); statErr != nil || !info.IsDir() {
+			log.Fatalf("Failed to create images directory: %v", err)
+		}
+		log.Printf("Could not create images directory (%v), using the existing one", err)
+	}
+
+	if *selfTest {
+		go runSelfTests()
+	}
+	go warnOrphans()
+	go runCleanup()
+	go collabHub.run()
+
+	http.HandleFunc("/", serveSPA)
+	http.HandleFunc("/robots.txt", robotsHandler)
+	http.HandleFunc("/assets/", assetsHandler)
+	http.HandleFunc("/info", infoHandler)
+	api := http.DefaultServeMux
+	if *multiTenant {
+		api = http.NewServeMux()
+		http.Handle("/api/v1/", tenantHandler(api))
+	} else {
+		http.Handle("/api/v1/", http.StripPrefix("/api/v1", api))
+	}
+	registerRoutes(api)
+	if *pluginDir != "" {
+		loadPlugins(*pluginDir, api)
+	}
+
+	fmt.Printf("Starting TilePuzzler server on http://localhost:%s\n", *port)
+	if !*noBrowser {
+		webbrowser.Open("http://localhost:" + *port)
+	}
+	if err := http.ListenAndServe(":"+*port, nil); err != nil {
+		log.Fatalf("Failed to start server: %v", err)
+	}
+}
+
+// registerRoutes adds the puzzle API and the images file server to mux.
+func registerRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/exportPuzzle", exportPuzzleHandler)
+	mux.HandleFunc("/exportPuzzleProgress", requirePuzzleAccess(exportPuzzleProgressHandler))
+	mux.HandleFunc("/exports/", exportsHandler)
+	mux.HandleFunc("/autoSolve", autoSolveHandler)
+	mux.HandleFunc("/uploadPuzzle", requireAPIKey(uploadPuzzleHandler))
+	mux.HandleFunc("/uploadProgress", uploadProgressHandler)
+	mux.HandleFunc("/generationStatus", requirePuzzleAccess(generationStatusHandler))
+	mux.HandleFunc("/createCollage", requireAPIKey(createCollageHandler))
+	mux.HandleFunc("/benchmarkCompression", benchmarkCompressionHandler)
+	mux.HandleFunc("/schema", schemaHandler)
+	mux.HandleFunc("/lintManifest", lintManifestHandler)
+	mux.HandleFunc("/manifestDiff", manifestDiffHandler)
+	mux.HandleFunc("/prefetch", requirePuzzleAccess(prefetchHandler))
+	mux.HandleFunc("/tile", requirePuzzleAccess(tileHandler))
+	mux.HandleFunc("/downloadPieces", requirePuzzleAccess(downloadPiecesHandler))
+	mux.HandleFunc("/exportTileset", exportTilesetHandler)
+	mux.HandleFunc("/uploaderReport", uploaderReportHandler)
+	mux.HandleFunc("/orphanedEntries", orphanedEntriesHandler)
+	mux.HandleFunc("/admin/generateApiKey", generateAPIKeyHandler)
+	mux.HandleFunc("/admin/revokeApiKey", revokeAPIKeyHandler)
+	mux.HandleFunc("/shareToken", shareTokenHandler)
+	mux.HandleFunc("/pruneOrphans", pruneOrphansHandler)
+	mux.HandleFunc("/backupAll", backupAllHandler)
+	mux.HandleFunc("/replacePiece", replacePieceHandler)
+	mux.HandleFunc("/swapPieces", swapPiecesHandler)
+	mux.HandleFunc("/rotatePiece", rotatePieceHandler)
+	mux.HandleFunc("/flipPuzzle", flipPuzzleHandler)
+	mux.HandleFunc("/rescalePuzzle", rescalePuzzleHandler)
+	mux.HandleFunc("/equalizeHistogram", equalizeHistogramHandler)
+	mux.HandleFunc("/checkTileQuality", checkTileQualityHandler)
+	mux.HandleFunc("/embed", requirePuzzleAccess(embedHandler))
+	mux.HandleFunc("/tileAltText", requirePuzzleAccess(tileAltTextHandler))
+	mux.HandleFunc("/flagComplete", flagCompleteHandler)
+	mux.HandleFunc("/completions", requirePuzzleAccess(completionsHandler))
+	mux.HandleFunc("/leaderboardSocket", requirePuzzleAccess(leaderboardSocketHandler))
+	mux.HandleFunc("/ws", requirePuzzleAccess(wsHandler))
+	mux.HandleFunc("/gridOverlay", requirePuzzleAccess(gridOverlayHandler))
+	mux.HandleFunc("/assemblyPreview", requirePuzzleAccess(assemblyPreviewHandler))
+	mux.HandleFunc("/manifest", requirePuzzleAccess(manifestHandler))
+	mux.HandleFunc("/puzzleMeta", requirePuzzleAccess(puzzleMetaHandler))
+	mux.HandleFunc("/puzzleStats", requirePuzzleAccess(puzzleStatsHandler))
+	mux.HandleFunc("/puzzles", puzzlesHandler)
+	mux.HandleFunc("/puzzles/", puzzleResourceHandler)
+	mux.HandleFunc("/puzzle", requirePuzzleAccess(puzzleHandler))
+	mux.HandleFunc("/deletePuzzle", requirePuzzleAccess(deletePuzzleHandler))
+	mux.HandleFunc("/renamePuzzle", renamePuzzleHandler)
+	mux.HandleFunc("/restorePuzzle", requirePuzzleAccess(restorePuzzleHandler))
+	mux.HandleFunc("/saveState", saveStateHandler)
+	mux.HandleFunc("/loadState", requirePuzzleAccess(loadStateHandler))
+	mux.HandleFunc("/savePuzzle", savePuzzleHandler)
+	mux.HandleFunc("/loadPuzzle", requirePuzzleAccess(loadPuzzleHandler))
+	mux.HandleFunc("/hint", hintHandler)
+	mux.HandleFunc("/checkSolution", checkSolutionHandler)
+	mux.HandleFunc("/images/", imagesHandler)
+}
+
+// Helper: Resize image
+func resizeImage(img image.Image, width, height int) image.Image {
+	return resize.Resize(uint(width), uint(height), img, resize.Lanczos3)
+}
+
+type ExportPayload struct {
+	Folder      string                `json:"folder"`
+	Placements  map[string]PlacedTile `json:"placements"`            // keyed by "row,col"
+	Transparent bool                  `json:"transparent,omitempty"` // keep empty cells see-through and always write alpha: png or webp only
+	CanvasRows  int                   `json:"canvasRows,omitempty"`  // canvas size in cells; inferred from placements when 0
+	CanvasCols  int                   `json:"canvasCols,omitempty"`
+	TileSize    int                   `json:"tileSize,omitempty"` // overrides the manifest's tile size
+}
+
+func serveSPA(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Check if an external tilepuzzler.html exists
+	_, err := os.Stat("tilepuzzler.html")
+	if err == nil {
+		// If it exists, serve the external file.
+		// This allows for easy development and customization without rebuilding.
+		log.Println("Serving external tilepuzzler.html")
+		http.ServeFile(w, r, "tilepuzzler.html")
+		return
+	}
+
+	// If the external file doesn't exist, serve the embedded version.
+	log.Println("Serving embedded tilepuzzler.html")
+	file, err := embeddedFS.Open("tilepuzzler.html")
+	if err != nil {
+		log.Printf("FATAL: Could not open embedded tilepuzzler.html: %v", err)
+		http.Error(w, "Internal Server Error: Embedded file not found.", http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		log.Printf("FATAL: Could not stat embedded tilepuzzler.html: %v", err)
+		http.Error(w, "Internal Server Error: Cannot stat embedded file.", http.StatusInternalServerError)
+		return
+	}
+
+	// Read the file content into a buffer to create an io.ReadSeeker, which http.ServeContent needs.
+	content, err := io.ReadAll(file)
+	if err != nil {
+		log.Printf("FATAL: Could not read embedded tilepuzzler.html: %v", err)
+		http.Error(w, "Internal Server Error: Cannot read embedded file.", http.StatusInternalServerError)
+		return
+	}
+	reader := bytes.NewReader(content)
+
+	// Use http.ServeContent to handle caching headers correctly.
+	http.ServeContent(w, r, "tilepuzzler.html", stat.ModTime(), reader)
+}
+
+// assetsHandler serves custom stylesheets and scripts from an optional
+// assets/ directory in the working directory.
+func assetsHandler(w http.ResponseWriter, r *http.Request) {
+	if info, err := os.Stat("assets"); err != nil || !info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	http.StripPrefix("/assets/", http.FileServer(http.Dir("assets"))).ServeHTTP(w, r)
+}
+
+func robotsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	if *allowIndexing {
+		io.WriteString(w, "User-agent: *\nAllow: /\n")
+		return
+	}
+	io.WriteString(w, "User-agent: *\nDisallow: /images/\nDisallow: /tile\nDisallow: /api/\n")
+}
+
+func exportPuzzleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var payload ExportPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	format, err := parseExportFormat(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	st := storeFor(r)
+	manifest, ok := checkExport(w, r, payload, format)
+	if !ok {
+		return
+	}
+	fmt.Printf("Exporting %s\n", payload.Folder)
+
+	cacheKey := st.exportCacheKey(payload, format)
+	if data, ok := getCachedExport(cacheKey); ok {
+		w.Header().Set("X-Cache", "HIT")
+		format.setHeaders(w)
+		w.Write(data)
+		return
+	}
+
+	release, ok := st.acquireExportSlot(payload.Folder)
+	if !ok {
+		w.Header().Set("Retry-After", "5")
+		http.Error(w, "Too many exports of this puzzle in progress", http.StatusServiceUnavailable)
+		return
+	}
+	defer release()
+
+	dst := assembleExport(st, payload, manifest, nil)
+	fmt.Printf("returning completed image\n")
+
+	// Stream the image to the client as it is encoded, keeping a copy for
+	// the export cache. Once the first chunk has gone out the status can no
+	// longer be changed, so encoding errors are only logged.
+	w.Header().Set("X-Cache", "MISS")
+	format.setHeaders(w)
+	var buf bytes.Buffer
+	out := &flushWriter{w: w}
+	if err := format.encode(io.MultiWriter(out, &buf), payload.exportImage(dst)); err != nil {
+		log.Printf("Failed to encode %s for %s: %v", format.Name, payload.Folder, err)
+		return
+	}
+	out.Flush()
+	st.putCachedExport(cacheKey, payload.Folder, buf.Bytes())
+}
+
+const (
+	// maxExportCells is the most rows or columns an export canvas may have
+	maxExportCells = 100
+	// maxExportPixels bounds the area of an export canvas, which is held in
+	// memory whole; a 20x20 puzzle of 512px tiles fits
+	maxExportPixels = 1 << 27
+)
+
+// checkExport validates an export request and loads the puzzle's manifest,
+// with the tile size the export asks for. On failure it has already replied.
+func checkExport(w http.ResponseWriter, r *http.Request, payload ExportPayload, format exportFormat) (*Manifest, bool) {
+	if payload.Transparent && format.Name == "jpeg" {
+		http.Error(w, "transparent exports need png or webp", http.StatusBadRequest)
+		return nil, false
+	}
+	if payload.CanvasRows < 0 || payload.CanvasCols < 0 || payload.CanvasRows > maxExportCells || payload.CanvasCols > maxExportCells {
+		http.Error(w, fmt.Sprintf("canvasRows and canvasCols must be between 0 and %d", maxExportCells), http.StatusBadRequest)
+		return nil, false
+	}
+	// Rescaled puzzles can have any tile size, so only uploads are held to
+	// powers of two
+	if payload.TileSize < 0 || payload.TileSize > 2048 {
+		http.Error(w, "Invalid tileSize: must be between 1 and 2048", http.StatusBadRequest)
+		return nil, false
+	}
+	if !validFolderName(payload.Folder) || !filepath.IsLocal(payload.Folder) {
+		http.Error(w, "Invalid folder name", http.StatusBadRequest)
+		return nil, false
+	}
+	if !requirePuzzleVisible(w, r, payload.Folder) {
+		return nil, false
+	}
+	files := make([]string, 0, len(payload.Placements))
+	for pos, tile := range payload.Placements {
+		var r, c int
+		if n, _ := fmt.Sscanf(pos, "%d,%d", &r, &c); n != 2 || r < 0 || c < 0 || r >= maxExportCells || c >= maxExportCells {
+			http.Error(w, fmt.Sprintf("Invalid position %q: must be row,col with both below %d", pos, maxExportCells), http.StatusBadRequest)
+			return nil, false
+		}
+		if err := tile.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return nil, false
+		}
+		files = append(files, tile.File)
+	}
+
+	manifest, err := storeFor(r).loadManifest(payload.Folder)
+	if err != nil {
+		http.Error(w, "Error reading manifest.json: "+err.Error(), http.StatusNotFound)
+		return nil, false
+	}
+	if unknown := manifest.unknownPieces(files); len(unknown) > 0 {
+		writeUnknownPieces(w, http.StatusBadRequest, unknown)
+		return nil, false
+	}
+	if payload.TileSize > 0 {
+		manifest.TileSize = payload.TileSize
+	}
+	rows, cols := payload.canvasGrid()
+	if width, height := manifest.columnX(cols), rows*manifest.effectiveTileSize(); width*height > maxExportPixels {
+		http.Error(w, fmt.Sprintf("Export too large: %dx%d pixels, at most %d in all", width, height, maxExportPixels), http.StatusBadRequest)
+		return nil, false
+	}
+	return manifest, true
+}
+
+// canvasGrid returns the size of an export's canvas in cells: canvasRows
+// and canvasCols, or else just large enough for the placements.
+func (p ExportPayload) canvasGrid() (rows, cols int) {
+	for pos := range p.Placements {
+		var r, c int
+		fmt.Sscanf(pos, "%d,%d", &r, &c)
+		rows, cols = max(rows, r+1), max(cols, c+1)
+	}
+	if p.CanvasRows > 0 {
+		rows = p.CanvasRows
+	}
+	if p.CanvasCols > 0 {
+		cols = p.CanvasCols
+	}
+	return rows, cols
+}
+
+// assembleExport draws the placed tiles of a checked export onto a new
+// canvas. progress, if set, is called after each tile.
+func assembleExport(st *store, payload ExportPayload, manifest *Manifest, progress func(done, total int)) *image.RGBA {
+	basePath := st.puzzlePath(payload.Folder)
+	tileSize := manifest.effectiveTileSize()
+	rows, cols := payload.canvasGrid()
+	canvasW := manifest.columnX(cols)
+	canvasH := rows * tileSize
+
+	// A new canvas is all zeros, i.e. fully transparent, and SafeDrawTile
+	// draws with draw.Over, so a transparent export keeps empty cells and
+	// tile alpha see-through
+	dst := NewSafeCanvas(canvasW, canvasH, tileSize)
+	dst.ColumnX = manifest.columnX
+
+	done := 0
+	for pos, tile := range payload.Placements {
+		drawPlacedTile(dst, basePath, pos, tile, rows, cols)
+		done++
+		if progress != nil {
+			progress(done, len(payload.Placements))
+		}
+	}
+	return dst.RGBA
+}
+
+// drawPlacedTile draws the tile placed at pos ("row,col"), skipping tiles
+// outside the canvas and tiles that cannot be read.
+func drawPlacedTile(dst *SafeCanvas, basePath, pos string, tile PlacedTile, rows, cols int) {
+	filename := tile.File
+	var r, c int
+	fmt.Sscanf(pos, "%d,%d", &r, &c)
+	if r >= rows || c >= cols {
+		log.Printf("WARNING: dropping tile %s at %s, outside the %dx%d canvas", filename, pos, rows, cols)
+		return
+	}
+
+	// The placements were checked against the manifest, but never let a
+	// filename leave pieces/
+	tilePath := filepath.Join(basePath, "pieces", filepath.Base(filename))
+	fmt.Printf("adding %s\n", filename)
+
+	tileFile, err := os.Open(tilePath)
+	if err != nil {
+		log.Printf("Failed to open tile %s: %v", filename, err)
+		return
+	}
+	img, _, err := image.Decode(tileFile)
+	tileFile.Close()
+	if err != nil {
+		log.Printf("Failed to decode tile %s: %v", filename, err)
+		return
+	}
+
+	dst.SafeDrawTile(r, c, tile.transform(img))
+}
+
+// flushWriter flushes the response every flushInterval bytes so that large
+// exports are sent in chunks while they are still being encoded.
+type flushWriter struct {
+	w       http.ResponseWriter
+	pending int
+}
+
+const flushInterval = 64 << 10
+
+func (fw *flushWriter) Write(p []byte) (int, error) {
+	n, err := fw.w.Write(p)
+	fw.pending += n
+	if fw.pending >= flushInterval {
+		fw.Flush()
+	}
+	return n, err
+}
+
+func (fw *flushWriter) Flush() {
+	if flusher, ok := fw.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	fw.pending = 0
+}
+
+func uploadPuzzleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Parse the multipart form
+	err := r.ParseMultipartForm(50 << 20) // 50 MB, for uncompressed TIFFs
+	if err != nil {
+		http.Error(w, "Error parsing multipart form: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	opts, err := parsePuzzleOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Get the image file
+	file, _, err := r.FormFile("image")
+	if err != nil {
+		http.Error(w, "Error retrieving the file: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	// Decode the image
+	img, err := decodeUpload(file)
+	if err != nil {
+		writeStatusError(w, err)
+		return
+	}
+
+	// Crop to the requested region, e.g. to drop a border or watermark
+	crop, ok, err := parseCropRect(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if ok {
+		b := img.Bounds()
+		crop = crop.Add(b.Min)
+		if !crop.In(b) {
+			http.Error(w, fmt.Sprintf("Crop rectangle is outside the %dx%d image", b.Dx(), b.Dy()), http.StatusBadRequest)
+			return
+		}
+		cropped := image.NewRGBA(image.Rect(0, 0, crop.Dx(), crop.Dy()))
+		draw.Draw(cropped, cropped.Bounds(), img, crop.Min, draw.Src)
+		img = cropped
+	}
+
+	createPuzzle(w, r, img, opts)
+}
+
+// parseCropRect reads the cropX, cropY, cropW and cropH form fields. They
+// must be given all together or not at all.
+func parseCropRect(r *http.Request) (image.Rectangle, bool, error) {
+	names := []string{"cropX", "cropY", "cropW", "cropH"}
+	var values [4]int
+	given := 0
+	for i, name := range names {
+		s := r.FormValue(name)
+		if s == "" {
+			continue
+		}
+		given++
+		v, err := strconv.Atoi(s)
+		if err != nil || v < 0 {
+			return image.Rectangle{}, false, fmt.Errorf("Invalid %s: must be a non-negative integer", name)
+		}
+		values[i] = v
+	}
+	switch {
+	case given == 0:
+		return image.Rectangle{}, false, nil
+	case given < len(names):
+		return image.Rectangle{}, false, errors.New("cropX, cropY, cropW and cropH must be given together")
+	case values[2] == 0 || values[3] == 0:
+		return image.Rectangle{}, false, errors.New("cropW and cropH must be positive")
+	}
+	x, y := values[0], values[1]
+	return image.Rect(x, y, x+values[2], y+values[3]), true, nil
+}
+
+// createPuzzle slices img into a new puzzle, registers it in imageIndex.json
+// and writes the JSON response. With opts.Async set it answers 202 with a job
+// ID straight away and does the work in the background; progress is then
+// available from /uploadProgress.
+func createPuzzle(w http.ResponseWriter, r *http.Request, img image.Image, opts puzzleOptions) {
+	st := storeFor(r)
+	folder := toSnakeCase(opts.Name)
+
+	// Turn away images the store already has, counting uploads still being
+	// sliced
+	hash := dHash(img)
+	existing, releaseHash, err := st.claimPHash(hash, folder)
+	if err != nil {
+		http.Error(w, "Error reading imageIndex.json: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if existing != "" {
+		writeDuplicate(w, existing)
+		return
+	}
+	opts.phash = formatPHash(hash)
+
+	// Wait for any other upload to the same name, so that a failed one
+	// does not turn this one away with a 409
+	unlockFolder := st.lockFolder(folder)
+	unlock := func() {
+		unlockFolder()
+		releaseHash()
+	}
+	puzzleDirName, err := st.reserveFolder(folder, opts.OnConflict == "suffix")
+	if err != nil {
+		unlock()
+		writeStatusError(w, err)
+		return
+	}
+
+	if opts.Async {
+		jobID, job := newUploadJob()
+		opts.progress = job.report
+		go func() {
+			defer unlock()
+			job.finish(puzzleDirName, buildPuzzle(st, puzzleDirName, img, opts))
+		}()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]any{
+			"status": "accepted",
+			"folder": puzzleDirName,
+			"jobId":  jobID,
+		})
+		return
+	}
+
+	err = buildPuzzle(st, puzzleDirName, img, opts)
+	unlock()
+	if err != nil {
+		writeStatusError(w, err)
+		return
+	}
+
+	// Return success response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"status": "ok",
+		"folder": puzzleDirName,
+		"_links": buildLinks(puzzleDirName, r),
+	})
+}
+
+// buildPuzzle slices img into folder and adds the puzzle to imageIndex.json.
+func buildPuzzle(st *store, folder string, img image.Image, opts puzzleOptions) error {
+	manifest, err := slicePuzzle(st, folder, img, opts)
+	if err != nil {
+		os.RemoveAll(st.puzzlePath(folder))
+		return err
+	}
+
+	// Update imageIndex.json
+	imageIndexMutex.Lock()
+	defer imageIndexMutex.Unlock()
+
+	imageIndex, err := st.loadImageIndex()
+	if err != nil {
+		return fmt.Errorf("Error reading imageIndex.json: %v", err)
+	}
+
+	entry := newImageEntry(opts.Name, folder, manifest)
+	entry.PHash = opts.phash
+	entry.Private = opts.Private
+	imageIndex.Images = append(imageIndex.Images, entry)
+
+	if err := st.saveImageIndex(imageIndex); err != nil {
+		return fmt.Errorf("Error writing imageIndex.json: %v", err)
+	}
+	return nil
+}
+
+func toSnakeCase(s string) string {
+	var result strings.Builder
+	for i, r := range s {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				result.WriteRune('_')
+			}
+			result.WriteRune(unicode.ToLower(r))
+		} else if r == ' ' || r == '-' {
+			result.WriteRune('_')
+		} else {
+			result.WriteRune(r)
+		}
+	}
+	return result.String()
+}
+
+// formValueOr returns the named form value, or def when it is empty.
+func formValueOr(r *http.Request, name, def string) string {
+	if v := r.FormValue(name); v != "" {
+		return v
+	}
+	return def
+}
+
+// validFolderName reports whether folder is a single, plain directory name
+// that is safe to join onto the images directory.
+func validFolderName(folder string) bool {
+	if folder == "" || folder == "." || folder == ".." {
+		return false
+	}
+	return !strings.ContainsAny(folder, `/\`) && !strings.Contains(folder, "..")
+}
+
+var (
+	imageIndexMutex sync.Mutex
+)
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestMain runs main instead of the tests when the test binary is started
+// as a server by a test.
+func TestMain(m *testing.M) {
+	if os.Getenv("TILEPUZZLER_TEST_MAIN") == "1" {
+		main()
+		return
+	}
+	os.Exit(m.Run())
+}
+
+// freePort returns a port nothing was listening on a moment ago.
+func freePort(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return strconv.Itoa(l.Addr().(*net.TCPAddr).Port)
+}
+
+func TestServerFlags(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts a server")
+	}
+	workDir, dataDir := t.TempDir(), t.TempDir()
+	port := freePort(t)
+
+	cmd := exec.Command(os.Args[0], "-port="+port, "-data="+dataDir, "-no-browser", "-selfTest=false")
+	cmd.Dir = workDir
+	cmd.Env = append(os.Environ(), "TILEPUZZLER_TEST_MAIN=1")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	base := "http://127.0.0.1:" + port
+	for deadline := time.Now().Add(10 * time.Second); ; {
+		res, err := http.Get(base + "/puzzles")
+		if err == nil {
+			res.Body.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("server did not start: %v", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	u, _ := url.Parse(base)
+	mustUpload(t, httputil.NewSingleHostReverseProxy(u), encodePNG(t, testImage(128, 128, 1)), map[string]string{
+		"name": "foo", "columns": "2", "tileSize": "64",
+	})
+
+	for _, name := range []string{"imageIndex.json", filepath.Join("foo", "manifest.json")} {
+		if _, err := os.Stat(filepath.Join(dataDir, name)); err != nil {
+			t.Errorf("%s not written to -data: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(workDir, "images")); !os.IsNotExist(err) {
+		t.Errorf("./images was created: %v", err)
+	}
+}